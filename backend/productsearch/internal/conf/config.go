@@ -11,11 +11,54 @@ type Config struct {
 	Port          int           `yaml:"port"`
 	Redis         Redis         `yaml:"redis"`
 	Miluvs        Miluvs        `yaml:"miluvs"`
+	VectorStore   VectorStore   `yaml:"vectorStore"`
 	OpenAI        OpenAI        `yaml:"openAI"`
+	Embedding     Embedding     `yaml:"embedding"`
 	ElasticSearch ElasticSearch `yaml:"elasticSearch"`
+	Search        Search        `yaml:"search"`
+	Admin         Admin         `yaml:"admin"`
+	RateLimit     RateLimit     `yaml:"rateLimit"`
 	ForceRebuild  bool          `yaml:"forceRebuild"`
 }
 
+/*
+@desc: rate limit rule by aspect (access, input, output), the most specific one wins:
+tier route, tier, route, default.
+*/
+type RateLimit struct {
+	Rules map[string]RateLimitRule `yaml:"rules"`
+	// Routes rules by route name (query, suggest) then by aspect.
+	Routes map[string]map[string]RateLimitRule `yaml:"routes"`
+	Tiers  map[string]RateLimitTier            `yaml:"tiers"`
+	// Fallback when redis fails: local limits each instance in memory, open allows and closed rejects the request.
+	Fallback string `yaml:"fallback"`
+	// FallbackRetrySec before trying redis again, 5 when 0.
+	FallbackRetrySec int64 `yaml:"fallbackRetrySec"`
+	// PerClient ids of the keys shared by many clients, e.g. the key of the frontend, limited by client ip.
+	PerClient []string `yaml:"perClient"`
+	// ClientHeader set by a trusted proxy with the client ip, the remote address when empty.
+	ClientHeader string `yaml:"clientHeader"`
+}
+
+type RateLimitRule struct {
+	IntervalSec int64 `yaml:"intervalSec"`
+	Limit       int64 `yaml:"limit"`
+	// Algorithm fixed, sliding or token, fixed by default.
+	Algorithm string `yaml:"algorithm"`
+}
+
+type RateLimitTier struct {
+	// ApiKeys ids of the keys in the tier, for keys issued without a tier.
+	ApiKeys []string                            `yaml:"apiKeys"`
+	Rules   map[string]RateLimitRule            `yaml:"rules"`
+	Routes  map[string]map[string]RateLimitRule `yaml:"routes"`
+}
+
+type Admin struct {
+	// ApiKeys allowed to call the admin endpoints.
+	ApiKeys []string `yaml:"apiKeys"`
+}
+
 type Mysql struct {
 	UserName string `yaml:"user_name"`
 	Password string `yaml:"password"`
@@ -41,15 +84,119 @@ type Miluvs struct {
 	DB       string `yaml:"db"`
 }
 
+type VectorStore struct {
+	// Type milvus, memory or elasticsearch, default milvus when miluvs endpoint is set.
+	Type string `yaml:"type"`
+	// Metric l2 or ip, used by the memory and elasticsearch store.
+	Metric string `yaml:"metric"`
+	// Dims dense_vector dims of the elasticsearch store, must match the embedding model.
+	Dims int `yaml:"dims"`
+	// Exact use script_score instead of approximate knn, for elasticsearch before 8.0.
+	Exact bool `yaml:"exact"`
+}
+
 type OpenAI struct {
 	Endpoint string `yaml:"endpoint"`
 	Token    string `yaml:"token"`
 }
 
+type Search struct {
+	// Mode fallback or hybrid.
+	Mode string `yaml:"mode"`
+	// Fusion rrf or weighted, used by hybrid mode.
+	Fusion        string  `yaml:"fusion"`
+	LexicalWeight float64 `yaml:"lexicalWeight"`
+	VectorWeight  float64 `yaml:"vectorWeight"`
+	RRFK          int     `yaml:"rrfK"`
+	// Highlight fragment option, used when the request asks for highlight.
+	Highlight SearchHighlight `yaml:"highlight"`
+	Suggest   SearchSuggest   `yaml:"suggest"`
+	// AutoCorrect re-runs a zero result query with the suggested spelling.
+	AutoCorrect bool `yaml:"autoCorrect"`
+	// VectorMaxWindow deepest vector result reachable by paging, the vector store has no offset in common.
+	VectorMaxWindow int `yaml:"vectorMaxWindow"`
+	// SkuPatterns regexes, a keyword matching any of them is looked up as sku.
+	SkuPatterns []string `yaml:"skuPatterns"`
+	// Boost function_score over the text relevance, a zero weight disables the function.
+	Boost SearchBoost `yaml:"boost"`
+}
+
+type SearchBoost struct {
+	Recency    RecencyBoost    `yaml:"recency"`
+	Popularity PopularityBoost `yaml:"popularity"`
+	// BoostMode how the boost combines with the relevance, default multiply.
+	BoostMode string `yaml:"boostMode"`
+	// ScoreMode how the functions combine, default sum.
+	ScoreMode string  `yaml:"scoreMode"`
+	MaxBoost  float64 `yaml:"maxBoost"`
+}
+
+type RecencyBoost struct {
+	Weight float64 `yaml:"weight"`
+	// Function gauss, exp or linear decay on updateTime.
+	Function string  `yaml:"function"`
+	Scale    string  `yaml:"scale"`
+	Offset   string  `yaml:"offset"`
+	Decay    float64 `yaml:"decay"`
+}
+
+type PopularityBoost struct {
+	Weight   float64 `yaml:"weight"`
+	Factor   float64 `yaml:"factor"`
+	Modifier string  `yaml:"modifier"`
+	// Missing popularity of a product without one.
+	Missing float64 `yaml:"missing"`
+}
+
+type SearchSuggest struct {
+	// TimeoutMs latency budget of a suggest request, es gives up and returns what it has.
+	TimeoutMs int `yaml:"timeoutMs"`
+	MaxSize   int `yaml:"maxSize"`
+}
+
+type SearchHighlight struct {
+	FragmentSize int    `yaml:"fragmentSize"`
+	Fragments    int    `yaml:"fragments"`
+	PreTag       string `yaml:"preTag"`
+	PostTag      string `yaml:"postTag"`
+}
+
+type Embedding struct {
+	// Provider openai, openai-compatible or hashing, default openai when openAI token is set.
+	Provider string `yaml:"provider"`
+	// Model alias(AdaEmbeddingV2, SmallEmbedding3, LargeEmbedding3) or model name of the provider.
+	Model string `yaml:"model"`
+	// Dimensions of the output vector, for models supporting shortened embeddings and hashing.
+	Dimensions int            `yaml:"dimensions"`
+	Endpoint   string         `yaml:"endpoint"`
+	Token      string         `yaml:"token"`
+	Batch      EmbeddingBatch `yaml:"batch"`
+	Cache      EmbeddingCache `yaml:"cache"`
+}
+
+type EmbeddingCache struct {
+	Enabled bool `yaml:"enabled"`
+	TTLSec  int  `yaml:"ttlSec"`
+}
+
+type EmbeddingBatch struct {
+	// MaxDocuments documents per request.
+	MaxDocuments int `yaml:"maxDocuments"`
+	// MaxTokens estimated tokens per request.
+	MaxTokens   int `yaml:"maxTokens"`
+	Concurrency int `yaml:"concurrency"`
+	// MaxRetries retries of a failed batch, -1 to disable.
+	MaxRetries int `yaml:"maxRetries"`
+	// BackoffMs first retry delay, doubled on each retry.
+	BackoffMs int `yaml:"backoffMs"`
+}
+
 type ElasticSearch struct {
 	Address  []string `yaml:"address"`
 	UserName string   `yaml:"userName"`
 	Password string   `yaml:"password"`
+	// RetainVersions index versions kept after a rebuild, including the active one.
+	RetainVersions int `yaml:"retainVersions"`
 }
 
 func Load(path string) (Config, error) {
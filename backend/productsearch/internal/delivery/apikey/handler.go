@@ -0,0 +1,196 @@
+package apikey
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
+	"github.com/ringbrew/gsv/service"
+	"github.com/ringbrew/newaim/productsearch/internal/delivery/common"
+	"github.com/ringbrew/newaim/productsearch/internal/domain"
+	"github.com/ringbrew/newaim/productsearch/internal/domain/apikey"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type Handler struct {
+	ctx  *domain.UseCaseContext
+	keys *apikey.UseCase
+}
+
+func NewHandler(ctx *domain.UseCaseContext, keys *apikey.UseCase) *Handler {
+	return &Handler{
+		ctx:  ctx,
+		keys: keys,
+	}
+}
+
+type IssueParam struct {
+	Owner      string     `json:"owner"`
+	Scopes     []string   `json:"scopes"`
+	Tier       string     `json:"tier"`
+	ExpireTime *time.Time `json:"expireTime"`
+}
+
+func (ip *IssueParam) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&ip.Owner: binding.Field{
+			Form:     "owner",
+			Required: true,
+		},
+		&ip.Scopes:     "scopes",
+		&ip.Tier:       "tier",
+		&ip.ExpireTime: "expireTime",
+	}
+}
+
+func (ip *IssueParam) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	if strings.TrimSpace(ip.Owner) == "" {
+		errs.Add([]string{"owner"}, "ValueError", "owner must not be blank")
+	}
+
+	for _, v := range ip.Scopes {
+		if !apikey.ValidScope(strings.TrimSpace(v)) {
+			errs.Add([]string{"scopes"}, "ValueError", fmt.Sprintf("invalid scope-[%s]", v))
+		}
+	}
+
+	if ip.ExpireTime != nil && !ip.ExpireTime.After(time.Now()) {
+		errs.Add([]string{"expireTime"}, "ValueError", "expireTime must be in the future")
+	}
+
+	return errs
+}
+
+type RotateParam struct {
+	// GraceSec the old secret keeps working after the rotation.
+	GraceSec int64 `json:"graceSec"`
+}
+
+func (rp *RotateParam) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&rp.GraceSec: "graceSec",
+	}
+}
+
+func (rp *RotateParam) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	if rp.GraceSec < 0 {
+		errs.Add([]string{"graceSec"}, "ValueError", "graceSec must not be negative")
+	}
+	return errs
+}
+
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	data, err := h.keys.List(r.Context())
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	for i := range data {
+		data[i] = data[i].View()
+	}
+
+	common.Render().JSON(w, http.StatusOK, data)
+}
+
+func (h *Handler) Issue(w http.ResponseWriter, r *http.Request) {
+	ip := IssueParam{}
+	if err := binding.Bind(r, &ip); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	scopes := make([]string, 0, len(ip.Scopes))
+	for _, v := range ip.Scopes {
+		if v = strings.TrimSpace(v); v != "" {
+			scopes = append(scopes, v)
+		}
+	}
+
+	k, key, err := h.keys.Issue(r.Context(), apikey.IssueRequest{
+		Owner:      strings.TrimSpace(ip.Owner),
+		Scopes:     scopes,
+		Tier:       strings.TrimSpace(ip.Tier),
+		ExpireTime: ip.ExpireTime,
+	})
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	// the only time the secret is shown.
+	common.Render().JSON(w, http.StatusOK, map[string]interface{}{
+		"key":    key,
+		"apiKey": k.View(),
+	})
+}
+
+func (h *Handler) Rotate(w http.ResponseWriter, r *http.Request) {
+	rp := RotateParam{}
+	if err := binding.Bind(r, &rp); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	k, key, err := h.keys.Rotate(r.Context(), mux.Vars(r)["id"], time.Duration(rp.GraceSec)*time.Second)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	common.Render().JSON(w, http.StatusOK, map[string]interface{}{
+		"key":    key,
+		"apiKey": k.View(),
+	})
+}
+
+func (h *Handler) Revoke(w http.ResponseWriter, r *http.Request) {
+	k, err := h.keys.Revoke(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	common.Render().JSON(w, http.StatusOK, k.View())
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, apikey.ErrKeyNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+	} else if errors.Is(err, apikey.ErrKeyRevoked) {
+		w.WriteHeader(http.StatusConflict)
+	} else if errors.Is(err, apikey.ErrInvalidScope) || errors.Is(err, apikey.ErrInvalidTier) {
+		w.WriteHeader(http.StatusBadRequest)
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	w.Write([]byte(err.Error()))
+}
+
+func (h *Handler) scoped(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return common.Scoped(h.keys, h.ctx.Config.Admin, scope, next)
+}
+
+func (h *Handler) HttpRoute() []service.HttpRoute {
+	result := []service.HttpRoute{
+		service.NewHttpRoute(http.MethodGet, "/apikey", h.scoped(apikey.ScopeAdmin, h.List), service.HttpMeta{
+			Remark: "API密钥列表",
+		}),
+		service.NewHttpRoute(http.MethodPost, "/apikey", h.scoped(apikey.ScopeAdmin, h.Issue), service.HttpMeta{
+			Remark:  "签发API密钥",
+			Request: IssueParam{},
+		}),
+		service.NewHttpRoute(http.MethodPost, "/apikey/{id}/rotate", h.scoped(apikey.ScopeAdmin, h.Rotate), service.HttpMeta{
+			Remark:  "轮换API密钥",
+			Request: RotateParam{},
+		}),
+		service.NewHttpRoute(http.MethodDelete, "/apikey/{id}", h.scoped(apikey.ScopeAdmin, h.Revoke), service.HttpMeta{
+			Remark: "吊销API密钥",
+		}),
+	}
+	return result
+}
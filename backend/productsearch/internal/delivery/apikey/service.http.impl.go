@@ -0,0 +1,39 @@
+package apikey
+
+import (
+	"github.com/ringbrew/gsv/service"
+	"github.com/ringbrew/newaim/productsearch/internal/domain"
+	"github.com/ringbrew/newaim/productsearch/internal/domain/apikey"
+)
+
+type Service struct {
+	ctx *domain.UseCaseContext
+
+	name   string
+	remark string
+	desc   service.Description
+}
+
+func NewService(ctx *domain.UseCaseContext, keys *apikey.UseCase) service.Service {
+	s := &Service{
+		ctx:    ctx,
+		name:   "apikey",
+		remark: "API密钥模块",
+	}
+
+	handler := NewHandler(ctx, keys)
+	s.desc.HttpRoute = append(s.desc.HttpRoute, handler.HttpRoute()...)
+	return s
+}
+
+func (s *Service) Name() string {
+	return s.name
+}
+
+func (s *Service) Remark() string {
+	return s.remark
+}
+
+func (s *Service) Description() service.Description {
+	return s.desc
+}
@@ -3,8 +3,10 @@ package delivery
 import (
 	"github.com/ringbrew/gsv/server"
 	"github.com/ringbrew/gsv/service"
+	apikeysvc "github.com/ringbrew/newaim/productsearch/internal/delivery/apikey"
 	"github.com/ringbrew/newaim/productsearch/internal/delivery/product"
 	"github.com/ringbrew/newaim/productsearch/internal/domain"
+	"github.com/ringbrew/newaim/productsearch/internal/domain/apikey"
 	"github.com/rs/cors"
 )
 
@@ -20,5 +22,7 @@ func NewServer(ctx *domain.UseCaseContext) server.Server {
 }
 
 func ServiceList(ctx *domain.UseCaseContext) []service.Service {
-	return []service.Service{product.NewService(ctx)}
+	// one key use case, a revoke through the apikey service evicts the key the product service cached.
+	keys := apikey.NewUseCase(ctx)
+	return []service.Service{product.NewService(ctx, keys), apikeysvc.NewService(ctx, keys)}
 }
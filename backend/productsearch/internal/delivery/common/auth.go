@@ -0,0 +1,76 @@
+package common
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
+	"github.com/ringbrew/newaim/productsearch/internal/domain/apikey"
+	"net/http"
+)
+
+const ApiKeyHeader = "X-Newaim-Api-Key"
+
+type apiKeyCtxKey struct{}
+
+/*
+@desc: the api key validated by Scoped.
+*/
+func ApiKeyFrom(ctx context.Context) *apikey.ApiKey {
+	k, _ := ctx.Value(apiKeyCtxKey{}).(*apikey.ApiKey)
+	return k
+}
+
+/*
+@desc: wrap the handler of a route with the scope it needs, a missing or unknown key answers 401,
+a key without the scope 403. the api keys of the admin config hold every scope, they issue the first keys.
+*/
+func Scoped(keys *apikey.UseCase, admin conf.Admin, scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		k, ok := authenticate(keys, admin, w, r)
+		if !ok {
+			return
+		}
+
+		if !k.HasScope(scope) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(fmt.Sprintf("scope-[%s] required", scope)))
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), apiKeyCtxKey{}, k)))
+	}
+}
+
+func authenticate(keys *apikey.UseCase, admin conf.Admin, w http.ResponseWriter, r *http.Request) (*apikey.ApiKey, bool) {
+	key := r.Header.Get(ApiKeyHeader)
+	if key == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("auth fail"))
+		return nil, false
+	}
+
+	for _, v := range admin.ApiKeys {
+		// constant time, the compare must not tell how much of the secret matched.
+		if v != "" && subtle.ConstantTimeCompare([]byte(v), []byte(key)) == 1 {
+			return &apikey.ApiKey{Id: "admin", Owner: "config", Scopes: []string{apikey.ScopeAdmin}}, true
+		}
+	}
+
+	k, err := keys.Validate(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, apikey.ErrKeyNotFound) || errors.Is(err, apikey.ErrInvalidKey) ||
+			errors.Is(err, apikey.ErrKeyExpired) || errors.Is(err, apikey.ErrKeyRevoked) {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else if errors.Is(err, apikey.ErrStoreUnavailable) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error()))
+		return nil, false
+	}
+
+	return k, true
+}
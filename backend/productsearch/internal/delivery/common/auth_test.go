@@ -0,0 +1,62 @@
+package common
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
+	"github.com/ringbrew/newaim/productsearch/internal/domain"
+	"github.com/ringbrew/newaim/productsearch/internal/domain/apikey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScoped(t *testing.T) {
+	mr := miniredis.RunT(t)
+	keys := apikey.NewUseCase(&domain.UseCaseContext{Redis: redis.NewClient(&redis.Options{Addr: mr.Addr()})})
+	admin := conf.Admin{ApiKeys: []string{"root"}}
+
+	_, reader, err := keys.Issue(context.Background(), apikey.IssueRequest{Owner: "shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, writer, err := keys.Issue(context.Background(), apikey.IssueRequest{Owner: "pim", Scopes: []string{apikey.ScopeCatalogWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if ApiKeyFrom(r.Context()) == nil {
+			t.Error("missing api key in the context")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	cases := []struct {
+		key   string
+		scope string
+		code  int
+	}{
+		{"", apikey.ScopeSearchRead, http.StatusUnauthorized},
+		{"unknown.secret", apikey.ScopeSearchRead, http.StatusUnauthorized},
+		{reader, apikey.ScopeSearchRead, http.StatusNoContent},
+		{reader, apikey.ScopeCatalogWrite, http.StatusForbidden},
+		{writer, apikey.ScopeCatalogWrite, http.StatusNoContent},
+		{writer, apikey.ScopeAdmin, http.StatusForbidden},
+		{"root", apikey.ScopeAdmin, http.StatusNoContent},
+		{"root", apikey.ScopeCatalogWrite, http.StatusNoContent},
+	}
+	for _, v := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/product", nil)
+		if v.key != "" {
+			r.Header.Set(ApiKeyHeader, v.key)
+		}
+		w := httptest.NewRecorder()
+
+		Scoped(keys, admin, v.scope, handler)(w, r)
+		if w.Code != v.code {
+			t.Errorf("key-[%s] scope-[%s] answer %d, want %d", v.key, v.scope, w.Code, v.code)
+		}
+	}
+}
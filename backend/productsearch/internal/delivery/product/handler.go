@@ -1,31 +1,62 @@
 package product
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
 	"github.com/mholt/binding"
 	"github.com/ringbrew/gsv/service"
 	"github.com/ringbrew/newaim/productsearch/internal/delivery/common"
 	"github.com/ringbrew/newaim/productsearch/internal/domain"
+	"github.com/ringbrew/newaim/productsearch/internal/domain/apikey"
 	"github.com/ringbrew/newaim/productsearch/internal/domain/product"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Handler struct {
-	ctx *domain.UseCaseContext
-	uc  *product.UseCase
+	ctx     *domain.UseCaseContext
+	uc      *product.UseCase
+	keys    *apikey.UseCase
+	limiter *Limiter
 }
 
-func NewHandler(ctx *domain.UseCaseContext, uc *product.UseCase) *Handler {
+func NewHandler(ctx *domain.UseCaseContext, uc *product.UseCase, keys *apikey.UseCase) *Handler {
 	return &Handler{
-		ctx: ctx,
-		uc:  uc,
+		ctx:     ctx,
+		uc:      uc,
+		keys:    keys,
+		limiter: NewLimiter(ctx),
 	}
 }
 
 type SearchParam struct {
-	From    int64  `json:"from"`
-	Size    int64  `json:"size"`
-	Keyword string `json:"keyword"`
+	From           int64      `json:"from"`
+	Size           int64      `json:"size"`
+	Keyword        string     `json:"keyword"`
+	Mode           string     `json:"mode"`
+	Fusion         string     `json:"fusion"`
+	LexicalWeight  *float64   `json:"lexicalWeight"`
+	VectorWeight   *float64   `json:"vectorWeight"`
+	CreateTimeFrom *time.Time `json:"createTimeFrom"`
+	CreateTimeTo   *time.Time `json:"createTimeTo"`
+	UpdateTimeFrom *time.Time `json:"updateTimeFrom"`
+	UpdateTimeTo   *time.Time `json:"updateTimeTo"`
+	SkuPrefix      string     `json:"skuPrefix"`
+	Category       []string   `json:"category"`
+	Brand          []string   `json:"brand"`
+	Facets         []string   `json:"facets"`
+	FacetSize      int        `json:"facetSize"`
+	Highlight      bool       `json:"highlight"`
+	AutoCorrect    *bool      `json:"autoCorrect"`
+	Cursor         string     `json:"cursor"`
+	Sort           []string   `json:"sort"`
 }
 
 func (sp *SearchParam) FieldMap(req *http.Request) binding.FieldMap {
@@ -35,23 +66,117 @@ func (sp *SearchParam) FieldMap(req *http.Request) binding.FieldMap {
 		&sp.Keyword: binding.Field{
 			Form: "keyword",
 		},
+		&sp.Mode:           "mode",
+		&sp.Fusion:         "fusion",
+		&sp.LexicalWeight:  "lexicalWeight",
+		&sp.VectorWeight:   "vectorWeight",
+		&sp.CreateTimeFrom: "createTimeFrom",
+		&sp.CreateTimeTo:   "createTimeTo",
+		&sp.UpdateTimeFrom: "updateTimeFrom",
+		&sp.UpdateTimeTo:   "updateTimeTo",
+		&sp.SkuPrefix:      "skuPrefix",
+		&sp.Category:       "category",
+		&sp.Brand:          "brand",
+		&sp.Facets:         "facets",
+		&sp.FacetSize:      "facetSize",
+		&sp.Highlight:      "highlight",
+		&sp.AutoCorrect:    "autoCorrect",
+		&sp.Cursor:         "cursor",
+		&sp.Sort:           "sort",
 	}
 }
 
-func (h *Handler) Query(w http.ResponseWriter, r *http.Request) {
-	apiKey := r.Header.Get("X-Newaim-Api-Key")
-	if apiKey == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("auth fail"))
-		return
+func (sp *SearchParam) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	switch sp.Mode {
+	case "", product.SearchModeFallback, product.SearchModeHybrid:
+	default:
+		errs.Add([]string{"mode"}, "ValueError", fmt.Sprintf("invalid mode-[%s]", sp.Mode))
+	}
+
+	switch sp.Fusion {
+	case "", product.FusionRRF, product.FusionWeighted:
+	default:
+		errs.Add([]string{"fusion"}, "ValueError", fmt.Sprintf("invalid fusion-[%s]", sp.Fusion))
+	}
+
+	if sp.LexicalWeight != nil && *sp.LexicalWeight < 0 {
+		errs.Add([]string{"lexicalWeight"}, "ValueError", "weight must not be negative")
+	}
+
+	if sp.VectorWeight != nil && *sp.VectorWeight < 0 {
+		errs.Add([]string{"vectorWeight"}, "ValueError", "weight must not be negative")
+	}
+
+	if sp.CreateTimeFrom != nil && sp.CreateTimeTo != nil && sp.CreateTimeFrom.After(*sp.CreateTimeTo) {
+		errs.Add([]string{"createTimeFrom", "createTimeTo"}, "ValueError", "createTimeFrom is after createTimeTo")
+	}
+
+	if sp.UpdateTimeFrom != nil && sp.UpdateTimeTo != nil && sp.UpdateTimeFrom.After(*sp.UpdateTimeTo) {
+		errs.Add([]string{"updateTimeFrom", "updateTimeTo"}, "ValueError", "updateTimeFrom is after updateTimeTo")
 	}
 
-	if err := NewLimiter(h.ctx).Check(r.Context(), CheckLimitInput{
+	for _, v := range sp.Facets {
+		if !product.ValidFacet(v) {
+			errs.Add([]string{"facets"}, "ValueError", fmt.Sprintf("invalid facet-[%s]", v))
+		}
+	}
+
+	if sp.FacetSize < 0 {
+		errs.Add([]string{"facetSize"}, "ValueError", "facetSize must not be negative")
+	}
+
+	if _, err := product.ParseSort(sp.Sort); err != nil {
+		errs.Add([]string{"sort"}, "ValueError", err.Error())
+	}
+
+	if sp.Cursor != "" && sp.From != 0 {
+		errs.Add([]string{"from", "cursor"}, "ValueError", "from must not be set with cursor")
+	}
+
+	return errs
+}
+
+func (sp *SearchParam) QueryRequest() product.QueryRequest {
+	// validated by Validate.
+	sort, _ := product.ParseSort(sp.Sort)
+
+	return product.QueryRequest{
+		Keyword: sp.Keyword,
+		From:    sp.From,
+		Size:    sp.Size,
+		Mode:    sp.Mode,
+		Hybrid: product.HybridOption{
+			Fusion:        sp.Fusion,
+			LexicalWeight: sp.LexicalWeight,
+			VectorWeight:  sp.VectorWeight,
+		},
+		Filter: product.Filter{
+			CreateTimeFrom: sp.CreateTimeFrom,
+			CreateTimeTo:   sp.CreateTimeTo,
+			UpdateTimeFrom: sp.UpdateTimeFrom,
+			UpdateTimeTo:   sp.UpdateTimeTo,
+			SkuPrefix:      strings.TrimSpace(sp.SkuPrefix),
+			Category:       sp.Category,
+			Brand:          sp.Brand,
+		},
+		Facets:      sp.Facets,
+		FacetSize:   sp.FacetSize,
+		Highlight:   sp.Highlight,
+		AutoCorrect: sp.AutoCorrect,
+		Cursor:      sp.Cursor,
+		Sort:        sort,
+	}
+}
+
+func (h *Handler) Query(w http.ResponseWriter, r *http.Request) {
+	key := common.ApiKeyFrom(r.Context())
+
+	if !h.limit(w, r, CheckLimitInput{
 		Aspect: AspectApiKeyAccess,
-		ApiKey: apiKey,
-	}); err != nil {
-		w.WriteHeader(http.StatusForbidden)
-		w.Write([]byte(err.Error()))
+		ApiKey: key.Id,
+		Tier:   key.Tier,
+		Route:  RouteQuery,
+	}) {
 		return
 	}
 
@@ -64,44 +189,413 @@ func (h *Handler) Query(w http.ResponseWriter, r *http.Request) {
 
 	sp.Keyword = strings.TrimSpace(sp.Keyword)
 
-	if err := NewLimiter(h.ctx).Check(r.Context(), CheckLimitInput{
+	if !h.limit(w, r, CheckLimitInput{
 		Aspect: AspectApiKeyInput,
-		ApiKey: apiKey,
+		ApiKey: key.Id,
+		Tier:   key.Tier,
 		Input:  sp,
-	}); err != nil {
-		w.WriteHeader(http.StatusForbidden)
+		Route:  RouteQuery,
+	}) {
+		return
+	}
+
+	resp, err := h.uc.Query(r.Context(), sp.QueryRequest())
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	if !h.limit(w, r, CheckLimitInput{
+		Aspect: AspectApiKeyOutput,
+		ApiKey: key.Id,
+		Tier:   key.Tier,
+		Output: resp.Data,
+		Route:  RouteQuery,
+	}) {
+		return
+	}
+
+	result := map[string]interface{}{
+		"total": resp.Total,
+		"data":  resp.Data,
+	}
+	if len(resp.Facets) > 0 {
+		result["facets"] = resp.Facets
+	}
+	if resp.SuggestedQuery != "" {
+		result["suggestedQuery"] = resp.SuggestedQuery
+		result["autoCorrected"] = resp.AutoCorrected
+	}
+	if resp.Estimated {
+		result["estimated"] = true
+	}
+	if sp.Cursor != "" {
+		result["nextCursor"] = resp.NextCursor
+	}
+	if resp.Redirect != nil {
+		result["redirect"] = resp.Redirect
+	}
+
+	common.Render().JSON(w, http.StatusOK, result)
+}
+
+/*
+@desc: check the rate limit and expose the tightest limit of the request in the headers,
+an exceeded limit answers 429 with the time to retry.
+*/
+func (h *Handler) limit(w http.ResponseWriter, r *http.Request, input CheckLimitInput) bool {
+	input.Client = h.clientIp(r)
+	result, err := h.limiter.Check(r.Context(), input)
+	if err != nil {
+		// the same json body as an exceeded limit, the error itself may tell the redis address.
+		log.Printf("check rate limit fail: %s", err.Error())
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrLimiterUnavailable) {
+			code = http.StatusServiceUnavailable
+			w.Header().Set("Retry-After", strconv.FormatInt(h.limiter.conf.FallbackRetrySec, 10))
+		}
+		common.Render().JSON(w, code, map[string]interface{}{
+			"error":  "rate limit check fail",
+			"aspect": input.Aspect.String(),
+		})
+		return false
+	}
+
+	if result.Limit > 0 {
+		header := w.Header()
+		remaining, err := strconv.ParseInt(header.Get("X-RateLimit-Remaining"), 10, 64)
+		if err != nil || result.Remaining <= remaining {
+			header.Set("X-RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+			header.Set("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+		}
+	}
+
+	if result.Allowed {
+		return true
+	}
+
+	retryAfter := int64(math.Ceil(result.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	common.Render().JSON(w, http.StatusTooManyRequests, map[string]interface{}{
+		"error":      "rate limit exceeded",
+		"aspect":     input.Aspect.String(),
+		"limit":      result.Limit,
+		"retryAfter": retryAfter,
+	})
+	return false
+}
+
+func (h *Handler) clientIp(r *http.Request) string {
+	if header := h.limiter.conf.ClientHeader; header != "" {
+		// the first address of a forwarded list is the client.
+		if v, _, _ := strings.Cut(r.Header.Get(header), ","); strings.TrimSpace(v) != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+/*
+@desc: redis and rate limit fallback state, degraded while the limiter falls back, with the embedding cache stats. the route is open,
+so the body only tells what is down, the errors go to the log.
+*/
+func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	status := "ok"
+	redisOk := true
+	if err := h.ctx.Redis.Ping(ctx).Err(); err != nil {
+		log.Printf("health redis fail: %s", err.Error())
+		status = "degraded"
+		redisOk = false
+	}
+
+	limitState := h.limiter.State()
+	if limitState.Active {
+		status = "degraded"
+	}
+
+	result := map[string]interface{}{
+		"status": status,
+		"redis": map[string]interface{}{
+			"ok": redisOk,
+		},
+		"rateLimit": map[string]interface{}{
+			"fallback": limitState.Active,
+		},
+	}
+	if stats := h.uc.EmbeddingCacheStats(); stats != nil {
+		result["embeddingCache"] = stats
+	}
+
+	common.Render().JSON(w, http.StatusOK, result)
+}
+
+func (h *Handler) Explain(w http.ResponseWriter, r *http.Request) {
+	sp := SearchParam{}
+	if err := binding.Bind(r, &sp); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	data, total, err := h.uc.Query(r.Context(), sp.Keyword, sp.From, sp.Size)
+	sp.Keyword = strings.TrimSpace(sp.Keyword)
+
+	resp, explain, err := h.uc.Explain(r.Context(), sp.QueryRequest())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		h.writeError(w, err)
+		return
+	}
+
+	common.Render().JSON(w, http.StatusOK, map[string]interface{}{
+		"total":          resp.Total,
+		"data":           resp.Data,
+		"suggestedQuery": resp.SuggestedQuery,
+		"autoCorrected":  resp.AutoCorrected,
+		"estimated":      resp.Estimated,
+		"redirect":       resp.Redirect,
+		"explain":        explain,
+	})
+}
+
+type SuggestParam struct {
+	Prefix string `json:"prefix"`
+	Size   int64  `json:"size"`
+}
+
+func (sp *SuggestParam) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&sp.Prefix: "prefix",
+		&sp.Size:   "size",
+	}
+}
+
+func (h *Handler) Suggest(w http.ResponseWriter, r *http.Request) {
+	key := common.ApiKeyFrom(r.Context())
+
+	if !h.limit(w, r, CheckLimitInput{
+		Aspect: AspectApiKeyAccess,
+		ApiKey: key.Id,
+		Tier:   key.Tier,
+		Route:  RouteSuggest,
+	}) {
+		return
+	}
+
+	sp := SuggestParam{}
+	if err := binding.Bind(r, &sp); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	if err := NewLimiter(h.ctx).Check(r.Context(), CheckLimitInput{
-		Aspect: AspectApiKeyOutput,
-		ApiKey: apiKey,
-		Output: data,
-	}); err != nil {
-		w.WriteHeader(http.StatusForbidden)
+	result, err := h.uc.Suggest(r.Context(), sp.Prefix, sp.Size)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
 	common.Render().JSON(w, http.StatusOK, map[string]interface{}{
-		"total": total,
-		"data":  data,
+		"data": result,
 	})
 }
 
+type ProductParam struct {
+	SKU         string  `json:"sku"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Category    string  `json:"category"`
+	Brand       string  `json:"brand"`
+	Popularity  float64 `json:"popularity"`
+}
+
+func (pp *ProductParam) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&pp.SKU: binding.Field{
+			Form:     "sku",
+			Required: true,
+		},
+		&pp.Title: binding.Field{
+			Form:     "title",
+			Required: true,
+		},
+		&pp.Description: "description",
+		&pp.Category:    "category",
+		&pp.Brand:       "brand",
+		&pp.Popularity:  "popularity",
+	}
+}
+
+func (pp *ProductParam) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	// the log modifier of the popularity boost fails on a negative value.
+	if pp.Popularity < 0 {
+		errs.Add([]string{"popularity"}, "ValueError", "popularity must not be negative")
+	}
+
+	return errs
+}
+
+func (pp *ProductParam) Product() *product.Product {
+	return &product.Product{
+		SKU:         strings.TrimSpace(pp.SKU),
+		Title:       pp.Title,
+		Description: pp.Description,
+		Category:    strings.TrimSpace(pp.Category),
+		Brand:       strings.TrimSpace(pp.Brand),
+		Popularity:  pp.Popularity,
+	}
+}
+
+func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
+	pp := ProductParam{}
+	if err := binding.Bind(r, &pp); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	p := pp.Product()
+	if err := h.uc.Create(r.Context(), p); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	// the embedding is internal and large.
+	p.Vector = nil
+	common.Render().JSON(w, http.StatusOK, p)
+}
+
+func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
+	p, err := h.uc.Get(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	common.Render().JSON(w, http.StatusOK, p)
+}
+
+func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
+	pp := ProductParam{}
+	if err := binding.Bind(r, &pp); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	p := pp.Product()
+	p.SetId(mux.Vars(r)["id"])
+	if err := h.uc.Update(r.Context(), p); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	p.Vector = nil
+	common.Render().JSON(w, http.StatusOK, p)
+}
+
+func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.uc.Delete(r.Context(), mux.Vars(r)["id"]); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) Versions(w http.ResponseWriter, r *http.Request) {
+	data, err := h.uc.Versions(r.Context())
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	common.Render().JSON(w, http.StatusOK, data)
+}
+
+func (h *Handler) Rollback(w http.ResponseWriter, r *http.Request) {
+	data, err := h.uc.Rollback(r.Context())
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	common.Render().JSON(w, http.StatusOK, data)
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, product.ErrProductNotFound) || errors.Is(err, product.ErrRuleNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+	} else if errors.Is(err, product.ErrNoRollbackVersion) || errors.Is(err, product.ErrProductExists) {
+		w.WriteHeader(http.StatusConflict)
+	} else if errors.Is(err, product.ErrInvalidCursor) || errors.Is(err, product.ErrPageTooDeep) {
+		w.WriteHeader(http.StatusBadRequest)
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	w.Write([]byte(err.Error()))
+}
+
+func (h *Handler) scoped(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return common.Scoped(h.keys, h.ctx.Config.Admin, scope, next)
+}
+
 func (h *Handler) HttpRoute() []service.HttpRoute {
 	result := []service.HttpRoute{
-		service.NewHttpRoute(http.MethodGet, "/product", h.Query, service.HttpMeta{
+		service.NewHttpRoute(http.MethodGet, "/product", h.scoped(apikey.ScopeSearchRead, h.Query), service.HttpMeta{
 			Remark: "查询产品",
 		}),
+		service.NewHttpRoute(http.MethodPost, "/product", h.scoped(apikey.ScopeCatalogWrite, h.Create), service.HttpMeta{
+			Remark:  "创建产品",
+			Request: ProductParam{},
+		}),
+		// registered before /product/{id}, the router matches in order.
+		service.NewHttpRoute(http.MethodGet, "/product/suggest", h.scoped(apikey.ScopeSearchRead, h.Suggest), service.HttpMeta{
+			Remark: "搜索联想",
+		}),
+		service.NewHttpRoute(http.MethodGet, "/product/health", h.Health, service.HttpMeta{
+			Remark: "健康检查",
+		}),
+		service.NewHttpRoute(http.MethodGet, "/product/explain", h.scoped(apikey.ScopeAdmin, h.Explain), service.HttpMeta{
+			Remark: "查询解释",
+		}),
+		service.NewHttpRoute(http.MethodGet, "/product/rule", h.scoped(apikey.ScopeAdmin, h.Rules), service.HttpMeta{
+			Remark: "运营规则列表",
+		}),
+		service.NewHttpRoute(http.MethodPost, "/product/rule", h.scoped(apikey.ScopeAdmin, h.CreateRule), service.HttpMeta{
+			Remark:  "创建运营规则",
+			Request: RuleParam{},
+		}),
+		service.NewHttpRoute(http.MethodPut, "/product/rule/{ruleId}", h.scoped(apikey.ScopeAdmin, h.UpdateRule), service.HttpMeta{
+			Remark:  "更新运营规则",
+			Request: RuleParam{},
+		}),
+		service.NewHttpRoute(http.MethodDelete, "/product/rule/{ruleId}", h.scoped(apikey.ScopeAdmin, h.DeleteRule), service.HttpMeta{
+			Remark: "删除运营规则",
+		}),
+		service.NewHttpRoute(http.MethodGet, "/product/index", h.scoped(apikey.ScopeAdmin, h.Versions), service.HttpMeta{
+			Remark: "索引版本列表",
+		}),
+		service.NewHttpRoute(http.MethodPost, "/product/index/rollback", h.scoped(apikey.ScopeAdmin, h.Rollback), service.HttpMeta{
+			Remark: "回滚索引版本",
+		}),
+		service.NewHttpRoute(http.MethodGet, "/product/{id}", h.scoped(apikey.ScopeSearchRead, h.Get), service.HttpMeta{
+			Remark: "获取产品",
+		}),
+		service.NewHttpRoute(http.MethodPut, "/product/{id}", h.scoped(apikey.ScopeCatalogWrite, h.Update), service.HttpMeta{
+			Remark:  "更新产品",
+			Request: ProductParam{},
+		}),
+		service.NewHttpRoute(http.MethodDelete, "/product/{id}", h.scoped(apikey.ScopeCatalogWrite, h.Delete), service.HttpMeta{
+			Remark: "删除产品",
+		}),
 	}
 	return result
 }
@@ -8,38 +8,169 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
 	"github.com/ringbrew/newaim/productsearch/internal/domain"
+	"github.com/ringbrew/newaim/productsearch/internal/domain/apikey"
 	"github.com/ringbrew/newaim/productsearch/internal/domain/product"
+	"log"
+	"sync"
+	"time"
 )
 
+const (
+	RouteQuery   = "query"
+	RouteSuggest = "suggest"
+
+	AlgorithmFixed   = "fixed"
+	AlgorithmSliding = "sliding"
+	AlgorithmToken   = "token"
+
+	FallbackLocal  = "local"
+	FallbackOpen   = "open"
+	FallbackClosed = "closed"
+)
+
+/*
+@desc: atomic check of each algorithm, KEYS[1] the limit key, ARGV the window in ms, the limit and a unique request id.
+every script returns {allowed, remaining, retry after in ms}.
+*/
+var limitScript = map[string]*redis.Script{
+	// fixed window, cheap but lets twice the limit through across the window boundary.
+	AlgorithmFixed: redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+local limit = tonumber(ARGV[2])
+if count <= limit then
+	return {1, limit - count, 0}
+end
+return {0, 0, redis.call('PTTL', KEYS[1])}
+`),
+	// sliding log, a sorted set of the accepted requests in the last window.
+	AlgorithmSliding: redis.NewScript(`
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], window)
+	return {1, limit - count - 1, 0}
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {0, 0, tonumber(oldest[2]) + window - now}
+`),
+	// token bucket of limit tokens refilled over the window, allows a burst of the limit.
+	AlgorithmToken: redis.NewScript(`
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local rate = limit / window
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or limit
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(limit, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], window)
+return {allowed, math.floor(tokens), retry}
+`),
+}
+
+// rules used when the config has none for the aspect.
+var defaultLimitRule = map[Aspect]AspectRuleEntry{
+	AspectApiKeyAccess: {
+		IntervalSec: 10,
+		Limit:       10,
+	},
+	AspectApiKeyInput: {
+		IntervalSec: 10,
+		Limit:       10,
+	},
+	AspectApiKeyOutput: {
+		IntervalSec: 10,
+		Limit:       10,
+	},
+}
+
 type Limiter struct {
 	rds  *redis.Client
-	rule map[Aspect]AspectRuleEntry
+	conf conf.RateLimit
+	// tier of an api key.
+	tier map[string]string
+	// perClient keys limited by client ip.
+	perClient map[string]bool
+
+	local *localLimiter
+	mu    sync.Mutex
+	state FallbackState
 }
 
+/*
+@desc: state of the redis fallback, the health endpoint tells whether it is active.
+*/
+type FallbackState struct {
+	Active bool       `json:"active"`
+	Policy string     `json:"policy"`
+	Since  *time.Time `json:"since,omitempty"`
+	Error  string     `json:"error,omitempty"`
+	// Checks answered by the fallback since it became active.
+	Checks int64 `json:"checks"`
+	// RetryAt of redis, checks before it skip redis.
+	RetryAt *time.Time `json:"retryAt,omitempty"`
+}
+
+var ErrLimiterUnavailable = errors.New("rate limiter unavailable")
+
 func NewLimiter(ctx *domain.UseCaseContext) *Limiter {
-	return &Limiter{
-		rds: ctx.Redis,
-		rule: map[Aspect]AspectRuleEntry{
-			AspectApiKeyAccess: {
-				IntervalSec: 10,
-				Limit:       10,
-			},
-			AspectApiKeyInput: {
-				IntervalSec: 10,
-				Limit:       10,
-			},
-			AspectApiKeyOutput: {
-				IntervalSec: 10,
-				Limit:       10,
-			},
-		},
+	l := &Limiter{
+		rds:       ctx.Redis,
+		conf:      ctx.Config.RateLimit,
+		tier:      make(map[string]string),
+		perClient: make(map[string]bool),
+		local:     newLocalLimiter(),
+	}
+
+	switch l.conf.Fallback {
+	case FallbackOpen, FallbackClosed:
+	default:
+		l.conf.Fallback = FallbackLocal
+	}
+	if l.conf.FallbackRetrySec <= 0 {
+		l.conf.FallbackRetrySec = 5
 	}
+	l.state.Policy = l.conf.Fallback
+
+	for _, v := range l.conf.PerClient {
+		l.perClient[v] = true
+	}
+	// every caller of an unverified key would share its limit.
+	l.perClient[apikey.UnverifiedId] = true
+
+	for name, t := range l.conf.Tiers {
+		for _, v := range t.ApiKeys {
+			l.tier[v] = name
+		}
+	}
+
+	return l
 }
 
 type AspectRuleEntry struct {
 	IntervalSec int64
 	Limit       int64
+	Algorithm   string
 }
 type Aspect int
 
@@ -50,8 +181,21 @@ const (
 	AspectApiKeyOutput
 )
 
-func (a *Aspect) GenKey(apiKey string, input SearchParam, output []product.Product) (string, error) {
-	format := "newaim_product_service_apikey_%s_aspect_%d_%s_limit"
+func (a Aspect) String() string {
+	switch a {
+	case AspectApiKeyAccess:
+		return "access"
+	case AspectApiKeyInput:
+		return "input"
+	case AspectApiKeyOutput:
+		return "output"
+	default:
+		return "invalid"
+	}
+}
+
+func (a *Aspect) GenKey(apiKey string, route string, input interface{}, output []product.Product) (string, error) {
+	format := "newaim_product_service_apikey_%s_route_%s_aspect_%d_%s_limit"
 
 	getDataMd5 := func(input interface{}) (string, error) {
 		sData, err := json.Marshal(input)
@@ -70,58 +214,203 @@ func (a *Aspect) GenKey(apiKey string, input SearchParam, output []product.Produ
 	switch *a {
 	//case
 	case AspectApiKeyAccess:
-		return fmt.Sprintf(format, apiKey, *a, "access"), nil
+		return fmt.Sprintf(format, apiKey, route, *a, "access"), nil
 	case AspectApiKeyInput:
 		dataKey, err := getDataMd5(input)
 		if err != nil {
 			return "", err
 		}
-		return fmt.Sprintf(format, apiKey, *a, dataKey), nil
+		return fmt.Sprintf(format, apiKey, route, *a, dataKey), nil
 	case AspectApiKeyOutput:
 		dataKey, err := getDataMd5(output)
 		if err != nil {
 			return "", err
 		}
-		return fmt.Sprintf(format, apiKey, *a, dataKey), nil
+		return fmt.Sprintf(format, apiKey, route, *a, dataKey), nil
 	default:
 		return "", nil
 	}
 }
 
+/*
+@desc: the most specific rule of the api key, route and aspect.
+*/
+func (lc *Limiter) Rule(apiKey string, route string, aspect Aspect) AspectRuleEntry {
+	return lc.rule(lc.tier[apiKey], route, aspect)
+}
+
+func (lc *Limiter) rule(tier string, route string, aspect Aspect) AspectRuleEntry {
+	name := aspect.String()
+
+	candidates := make([]map[string]conf.RateLimitRule, 0, 4)
+	if t, found := lc.conf.Tiers[tier]; found {
+		candidates = append(candidates, t.Routes[route], t.Rules)
+	}
+	candidates = append(candidates, lc.conf.Routes[route], lc.conf.Rules)
+
+	for _, v := range candidates {
+		if r, found := v[name]; found && r.IntervalSec > 0 && r.Limit > 0 {
+			return AspectRuleEntry{
+				IntervalSec: r.IntervalSec,
+				Limit:       r.Limit,
+				Algorithm:   r.Algorithm,
+			}
+		}
+	}
+
+	return defaultLimitRule[aspect]
+}
+
 type CheckLimitInput struct {
 	Aspect Aspect
 	ApiKey string
-	Input  SearchParam
+	// Tier of the api key, the tier listing the api key in the config when empty.
+	Tier string
+	// Client ip of the caller, counted apart when the api key is in perClient.
+	Client string
+	Route  string
+	Input  interface{}
 	Output []product.Product
 }
 
-func (lc *Limiter) Check(ctx context.Context, input CheckLimitInput) error {
-	key, err := input.Aspect.GenKey(input.ApiKey, input.Input, input.Output)
+type CheckLimitOutput struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	// RetryAfter until the window of the exceeded limit ends.
+	RetryAfter time.Duration
+}
+
+func (lc *Limiter) Check(ctx context.Context, input CheckLimitInput) (CheckLimitOutput, error) {
+	limitKey := input.ApiKey
+	if lc.perClient[input.ApiKey] && input.Client != "" {
+		limitKey = input.ApiKey + "_" + input.Client
+	}
+
+	key, err := input.Aspect.GenKey(limitKey, input.Route, input.Input, input.Output)
 	if err != nil {
-		return err
+		return CheckLimitOutput{}, err
 	}
 
 	if key == "" {
-		return nil
+		return CheckLimitOutput{Allowed: true}, nil
 	}
 
-	c, err := lc.rds.Eval(ctx,
-		"local v = redis.call('INCR', KEYS[1]) if v == 1 then redis.call('EXPIRE', KEYS[1], ARGV[1]) end return v",
-		[]string{key},
-		lc.rule[input.Aspect].IntervalSec).Result()
+	tier := input.Tier
+	if tier == "" {
+		tier = lc.tier[input.ApiKey]
+	}
+
+	rule := lc.rule(tier, input.Route, input.Aspect)
+	if _, found := limitScript[rule.Algorithm]; !found {
+		rule.Algorithm = AlgorithmFixed
+	}
+
+	if lc.skipRedis() {
+		return lc.fallback(key, rule)
+	}
+
+	result, err := lc.check(ctx, key, rule)
 	if err != nil {
-		return err
+		// a canceled request says nothing about redis.
+		if ctx.Err() != nil {
+			return CheckLimitOutput{}, ctx.Err()
+		}
+
+		lc.trip(err)
+		return lc.fallback(key, rule)
+	}
+
+	lc.recover()
+	return result, nil
+}
+
+func (lc *Limiter) check(ctx context.Context, key string, rule AspectRuleEntry) (CheckLimitOutput, error) {
+	// the algorithms keep different redis types, a key per algorithm.
+	c, err := limitScript[rule.Algorithm].Run(ctx, lc.rds,
+		[]string{fmt.Sprintf("%s_%s", key, rule.Algorithm)},
+		rule.IntervalSec*1000, rule.Limit, product.NewIdGenerator().NewId()).Result()
+	if err != nil {
+		return CheckLimitOutput{}, err
+	}
+
+	reply, ok := c.([]interface{})
+	if !ok || len(reply) != 3 {
+		return CheckLimitOutput{}, fmt.Errorf("unexpected limit reply: %v", c)
 	}
+	allowed, _ := reply[0].(int64)
+	remaining, _ := reply[1].(int64)
+	retryMs, _ := reply[2].(int64)
 
-	count := c.(int64)
+	result := CheckLimitOutput{
+		Allowed:   allowed == 1,
+		Limit:     rule.Limit,
+		Remaining: remaining,
+	}
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
 
-	if limit := lc.rule[input.Aspect].Limit; count > limit {
-		if count > 20*limit {
-			lc.rds.Set(ctx, key, 20*limit, 0)
+	if !result.Allowed {
+		if retryMs <= 0 {
+			retryMs = rule.IntervalSec * 1000
 		}
+		result.RetryAfter = time.Duration(retryMs) * time.Millisecond
+	}
+
+	return result, nil
+}
+
+func (lc *Limiter) State() FallbackState {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.state
+}
+
+// an active fallback skips redis until the retry time, a down redis would slow every check.
+func (lc *Limiter) skipRedis() bool {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.state.Active && lc.state.RetryAt != nil && time.Now().Before(*lc.state.RetryAt)
+}
 
-		return errors.New("fetch forbidden")
+func (lc *Limiter) trip(err error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	now := time.Now()
+	if !lc.state.Active {
+		log.Printf("rate limit redis fail, fallback-[%s]: %s", lc.state.Policy, err.Error())
+		lc.state.Active = true
+		lc.state.Since = &now
+		lc.state.Checks = 0
 	}
+	retryAt := now.Add(time.Duration(lc.conf.FallbackRetrySec) * time.Second)
+	lc.state.RetryAt = &retryAt
+	lc.state.Error = err.Error()
+}
+
+func (lc *Limiter) recover() {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
 
-	return nil
+	if lc.state.Active {
+		log.Printf("rate limit redis recover after %d fallback check", lc.state.Checks)
+		lc.state = FallbackState{Policy: lc.state.Policy}
+	}
+}
+
+func (lc *Limiter) fallback(key string, rule AspectRuleEntry) (CheckLimitOutput, error) {
+	lc.mu.Lock()
+	lc.state.Checks++
+	lc.mu.Unlock()
+
+	switch lc.conf.Fallback {
+	case FallbackOpen:
+		return CheckLimitOutput{Allowed: true}, nil
+	case FallbackClosed:
+		return CheckLimitOutput{}, ErrLimiterUnavailable
+	default:
+		return lc.local.Check(key, rule), nil
+	}
 }
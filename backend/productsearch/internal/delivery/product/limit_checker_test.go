@@ -0,0 +1,304 @@
+package product
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
+	"github.com/ringbrew/newaim/productsearch/internal/domain"
+	"github.com/ringbrew/newaim/productsearch/internal/domain/apikey"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	lc := NewLimiter(&domain.UseCaseContext{
+		Redis: redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		Config: conf.Config{
+			RateLimit: conf.RateLimit{
+				Rules: map[string]conf.RateLimitRule{
+					"access": {IntervalSec: 10, Limit: 2},
+				},
+				Routes: map[string]map[string]conf.RateLimitRule{
+					RouteSuggest: {"access": {IntervalSec: 10, Limit: 5}},
+				},
+				Tiers: map[string]conf.RateLimitTier{
+					"premium": {
+						ApiKeys: []string{"gold"},
+						Rules:   map[string]conf.RateLimitRule{"access": {IntervalSec: 10, Limit: 100}},
+					},
+				},
+			},
+		},
+	})
+
+	rules := []struct {
+		apiKey string
+		route  string
+		aspect Aspect
+		limit  int64
+	}{
+		{"basic", RouteQuery, AspectApiKeyAccess, 2},
+		{"basic", RouteSuggest, AspectApiKeyAccess, 5},
+		{"gold", RouteSuggest, AspectApiKeyAccess, 100},
+		{"basic", RouteQuery, AspectApiKeyInput, 10},
+	}
+	for _, v := range rules {
+		if r := lc.Rule(v.apiKey, v.route, v.aspect); r.Limit != v.limit {
+			t.Errorf("unexpected limit of %s %s %s: %d", v.apiKey, v.route, v.aspect, r.Limit)
+		}
+	}
+
+	ctx := context.Background()
+	input := CheckLimitInput{Aspect: AspectApiKeyAccess, ApiKey: "basic", Route: RouteQuery}
+	for i := int64(1); i <= 3; i++ {
+		result, err := lc.Check(ctx, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if allowed := i <= 2; result.Allowed != allowed {
+			t.Fatalf("request %d allowed: %v", i, result.Allowed)
+		}
+		if want := 2 - i; want >= 0 && result.Remaining != want {
+			t.Errorf("request %d remaining: %d", i, result.Remaining)
+		}
+		if !result.Allowed && result.RetryAfter != 10*time.Second {
+			t.Errorf("unexpected retry after: %s", result.RetryAfter)
+		}
+	}
+
+	// another route counts on its own.
+	input.Route = RouteSuggest
+	if result, err := lc.Check(ctx, input); err != nil || !result.Allowed || result.Remaining != 4 {
+		t.Errorf("unexpected suggest result: %+v %v", result, err)
+	}
+}
+
+func TestLimiterAlgorithm(t *testing.T) {
+	mr := miniredis.RunT(t)
+	lc := NewLimiter(&domain.UseCaseContext{
+		Redis: redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+	})
+	ctx := context.Background()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mr.SetTime(now)
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		mr.SetTime(now)
+		mr.FastForward(d)
+	}
+
+	// a request opens the window, the rest of the limit comes right before the window boundary
+	// and a burst of the limit right after it.
+	burst := func(algorithm string, apiKey string) (int, int) {
+		lc.conf.Rules = map[string]conf.RateLimitRule{
+			"access": {IntervalSec: 10, Limit: 4, Algorithm: algorithm},
+		}
+		input := CheckLimitInput{Aspect: AspectApiKeyAccess, ApiKey: apiKey, Route: RouteQuery}
+
+		count := func(n int) int {
+			allowed := 0
+			for i := 0; i < n; i++ {
+				result, err := lc.Check(ctx, input)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Allowed {
+					allowed++
+				}
+			}
+			return allowed
+		}
+
+		count(1)
+		advance(9500 * time.Millisecond)
+		first := count(3)
+		advance(time.Second)
+		return first, count(4)
+	}
+
+	if first, second := burst(AlgorithmFixed, "fixed"); first != 3 || second != 4 {
+		t.Errorf("fixed window allows the limit on each side of the boundary: %d %d", first, second)
+	}
+
+	input := CheckLimitInput{Aspect: AspectApiKeyAccess, ApiKey: "sliding", Route: RouteQuery}
+	if first, second := burst(AlgorithmSliding, input.ApiKey); first != 3 || second != 1 {
+		t.Errorf("sliding log allows no more than the limit in a window: %d %d", first, second)
+	}
+
+	// the sliding log frees a slot once the oldest request of the window leaves it.
+	result, err := lc.Check(ctx, input)
+	if err != nil || result.Allowed || result.RetryAfter != 9*time.Second {
+		t.Fatalf("unexpected sliding result: %+v %v", result, err)
+	}
+
+	// 9.5s refill 3.8 tokens of the 4 per 10s, 1s 0.4 token.
+	input.ApiKey = "token"
+	if first, second := burst(AlgorithmToken, input.ApiKey); first != 3 || second != 1 {
+		t.Errorf("token bucket allows the refilled tokens: %d %d", first, second)
+	}
+
+	// 0.4 token left, a token refills in 1.5s, rounded up to the ms.
+	result, err = lc.Check(ctx, input)
+	if err != nil || result.Allowed || result.RetryAfter < 1500*time.Millisecond || result.RetryAfter > 1501*time.Millisecond {
+		t.Fatalf("unexpected token result: %+v %v", result, err)
+	}
+	advance(result.RetryAfter)
+	if result, err := lc.Check(ctx, input); err != nil || !result.Allowed {
+		t.Errorf("token bucket should refill after retry: %+v %v", result, err)
+	}
+}
+
+func TestLimiterFallback(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	input := CheckLimitInput{Aspect: AspectApiKeyAccess, ApiKey: "basic", Route: RouteQuery}
+
+	newLimiter := func(fallback string) *Limiter {
+		return NewLimiter(&domain.UseCaseContext{
+			Redis: redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+			Config: conf.Config{
+				RateLimit: conf.RateLimit{
+					Rules:    map[string]conf.RateLimitRule{"access": {IntervalSec: 10, Limit: 2}},
+					Fallback: fallback,
+				},
+			},
+		})
+	}
+
+	mr.SetError("ERR redis down")
+
+	lc := newLimiter("")
+	for i := 1; i <= 3; i++ {
+		result, err := lc.Check(ctx, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed := i <= 2; result.Allowed != allowed || result.Limit != 2 {
+			t.Fatalf("local request %d: %+v", i, result)
+		}
+	}
+	if state := lc.State(); !state.Active || state.Policy != FallbackLocal || state.Checks != 3 || state.Error == "" {
+		t.Errorf("unexpected fallback state: %+v", state)
+	}
+
+	if _, err := newLimiter(FallbackClosed).Check(ctx, input); err != ErrLimiterUnavailable {
+		t.Errorf("closed fallback should reject: %v", err)
+	}
+	if result, err := newLimiter(FallbackOpen).Check(ctx, input); err != nil || !result.Allowed {
+		t.Errorf("open fallback should allow: %+v %v", result, err)
+	}
+
+	// redis is tried again after the retry time.
+	mr.SetError("")
+	lc.state.RetryAt = nil
+	if result, err := lc.Check(ctx, input); err != nil || !result.Allowed || result.Remaining != 1 {
+		t.Errorf("unexpected redis result: %+v %v", result, err)
+	}
+	if state := lc.State(); state.Active || state.Checks != 0 {
+		t.Errorf("fallback should be cleared: %+v", state)
+	}
+}
+
+func TestLocalLimiter(t *testing.T) {
+	ll := newLocalLimiter()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ll.now = func() time.Time { return now }
+
+	// the burst of TestLimiterAlgorithm.
+	cases := []struct {
+		algorithm string
+		second    int
+	}{
+		{AlgorithmFixed, 4},
+		{AlgorithmSliding, 1},
+		{AlgorithmToken, 1},
+	}
+	for _, v := range cases {
+		rule := AspectRuleEntry{IntervalSec: 10, Limit: 4, Algorithm: v.algorithm}
+		count := func(n int) int {
+			allowed := 0
+			for i := 0; i < n; i++ {
+				if ll.Check(v.algorithm, rule).Allowed {
+					allowed++
+				}
+			}
+			return allowed
+		}
+
+		count(1)
+		now = now.Add(9500 * time.Millisecond)
+		first := count(3)
+		now = now.Add(time.Second)
+		if second := count(4); first != 3 || second != v.second {
+			t.Errorf("unexpected %s burst: %d %d", v.algorithm, first, second)
+		}
+
+		if result := ll.Check(v.algorithm, rule); result.Allowed || result.RetryAfter <= 0 {
+			t.Errorf("unexpected %s result: %+v", v.algorithm, result)
+		}
+	}
+}
+
+func TestLimiterPerClient(t *testing.T) {
+	mr := miniredis.RunT(t)
+	lc := NewLimiter(&domain.UseCaseContext{
+		Redis: redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		Config: conf.Config{
+			RateLimit: conf.RateLimit{
+				Rules:     map[string]conf.RateLimitRule{"access": {IntervalSec: 10, Limit: 1}},
+				PerClient: []string{"public"},
+			},
+		},
+	})
+
+	ctx := context.Background()
+	allowed := func(apiKey, client string) bool {
+		result, err := lc.Check(ctx, CheckLimitInput{Aspect: AspectApiKeyAccess, ApiKey: apiKey, Client: client, Route: RouteQuery})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Allowed
+	}
+
+	// each client of a public key has its own limit.
+	if !allowed("public", "10.0.0.1") || !allowed("public", "10.0.0.2") || allowed("public", "10.0.0.1") {
+		t.Error("public key should be limited by client")
+	}
+	if !allowed(apikey.UnverifiedId, "10.0.0.1") || !allowed(apikey.UnverifiedId, "10.0.0.2") {
+		t.Error("unverified key should be limited by client")
+	}
+	// other keys are limited by key whatever the client.
+	if !allowed("private", "10.0.0.1") || allowed("private", "10.0.0.2") {
+		t.Error("private key should be limited by key")
+	}
+}
+
+func TestHandlerLimitError(t *testing.T) {
+	mr := miniredis.RunT(t)
+	h := &Handler{limiter: NewLimiter(&domain.UseCaseContext{
+		Redis: redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		Config: conf.Config{
+			RateLimit: conf.RateLimit{Fallback: FallbackClosed},
+		},
+	})}
+
+	mr.SetError("ERR redis down")
+	w := httptest.NewRecorder()
+	if h.limit(w, httptest.NewRequest(http.MethodGet, "/product", nil), CheckLimitInput{Aspect: AspectApiKeyAccess, ApiKey: "basic", Route: RouteQuery}) {
+		t.Fatal("closed fallback should reject")
+	}
+
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "5" {
+		t.Errorf("unexpected answer: %d %v", w.Code, w.Header())
+	}
+	if body := w.Body.String(); !strings.Contains(body, `"access"`) || strings.Contains(body, mr.Addr()) {
+		t.Errorf("unexpected body: %s", body)
+	}
+}
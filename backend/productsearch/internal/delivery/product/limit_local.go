@@ -0,0 +1,116 @@
+package product
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const localLimitSweep = time.Minute
+
+type localLimitEntry struct {
+	// count of the fixed window, or tokens of the bucket.
+	count  float64
+	ts     time.Time
+	expire time.Time
+	// accepted requests of the sliding log.
+	log []time.Time
+}
+
+/*
+@desc: in memory limiter of a single instance, the same algorithms as the redis scripts,
+takes over the limit keys while redis is unavailable.
+*/
+type localLimiter struct {
+	mu    sync.Mutex
+	now   func() time.Time
+	entry map[string]*localLimitEntry
+	sweep time.Time
+}
+
+func newLocalLimiter() *localLimiter {
+	return &localLimiter{
+		now:   time.Now,
+		entry: make(map[string]*localLimitEntry),
+	}
+}
+
+func (ll *localLimiter) Check(key string, rule AspectRuleEntry) CheckLimitOutput {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	now := ll.now()
+	window := time.Duration(rule.IntervalSec) * time.Second
+
+	// drop the expired keys once in a while, the map grows with the input and output aspects.
+	if now.Sub(ll.sweep) > localLimitSweep {
+		for k, v := range ll.entry {
+			if !now.Before(v.expire) {
+				delete(ll.entry, k)
+			}
+		}
+		ll.sweep = now
+	}
+
+	key = key + "_" + rule.Algorithm
+	e, found := ll.entry[key]
+	if !found || !now.Before(e.expire) {
+		e = &localLimitEntry{ts: now}
+		if rule.Algorithm == AlgorithmToken {
+			e.count = float64(rule.Limit)
+		}
+		ll.entry[key] = e
+	}
+
+	result := CheckLimitOutput{Limit: rule.Limit}
+	switch rule.Algorithm {
+	case AlgorithmSliding:
+		kept := e.log[:0]
+		for _, v := range e.log {
+			if v.After(now.Add(-window)) {
+				kept = append(kept, v)
+			}
+		}
+		e.log = kept
+
+		if int64(len(e.log)) < rule.Limit {
+			e.log = append(e.log, now)
+			e.expire = now.Add(window)
+			result.Allowed = true
+			result.Remaining = rule.Limit - int64(len(e.log))
+		} else {
+			result.RetryAfter = e.log[0].Add(window).Sub(now)
+		}
+	case AlgorithmToken:
+		rate := float64(rule.Limit) / float64(window.Milliseconds())
+		elapsed := float64(now.Sub(e.ts).Milliseconds())
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		e.count = math.Min(float64(rule.Limit), e.count+elapsed*rate)
+		e.ts = now
+		e.expire = now.Add(window)
+
+		if e.count >= 1 {
+			e.count--
+			result.Allowed = true
+		} else {
+			result.RetryAfter = time.Duration(math.Ceil((1-e.count)/rate)) * time.Millisecond
+		}
+		result.Remaining = int64(e.count)
+	default:
+		if e.count == 0 {
+			e.expire = now.Add(window)
+		}
+		e.count++
+
+		if int64(e.count) <= rule.Limit {
+			result.Allowed = true
+			result.Remaining = rule.Limit - int64(e.count)
+		} else {
+			result.RetryAfter = e.expire.Sub(now)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,144 @@
+package product
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
+	"github.com/ringbrew/newaim/productsearch/internal/delivery/common"
+	"github.com/ringbrew/newaim/productsearch/internal/domain/product"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type RuleParam struct {
+	Query    string   `json:"query"`
+	Match    string   `json:"match"`
+	Pin      []string `json:"pin"`
+	Bury     []string `json:"bury"`
+	Redirect string   `json:"redirect"`
+	Label    string   `json:"label"`
+	Enabled  *bool    `json:"enabled"`
+}
+
+func (rp *RuleParam) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&rp.Query: binding.Field{
+			Form:     "query",
+			Required: true,
+		},
+		&rp.Match:    "match",
+		&rp.Pin:      "pin",
+		&rp.Bury:     "bury",
+		&rp.Redirect: "redirect",
+		&rp.Label:    "label",
+		&rp.Enabled:  "enabled",
+	}
+}
+
+func (rp *RuleParam) Validate(req *http.Request, errs binding.Errors) binding.Errors {
+	if strings.TrimSpace(rp.Query) == "" {
+		errs.Add([]string{"query"}, "ValueError", "query must not be blank")
+	}
+
+	switch rp.Match {
+	case "", product.RuleMatchExact, product.RuleMatchContains:
+	default:
+		errs.Add([]string{"match"}, "ValueError", fmt.Sprintf("invalid match-[%s]", rp.Match))
+	}
+
+	if len(rp.Pin) == 0 && len(rp.Bury) == 0 && rp.Redirect == "" {
+		errs.Add([]string{"pin", "bury", "redirect"}, "ValueError", "rule needs a pin, bury or redirect")
+	}
+
+	// an absolute http url or a path of the site.
+	if rp.Redirect != "" {
+		u, err := url.Parse(rp.Redirect)
+		if err != nil || !(u.Scheme == "http" || u.Scheme == "https" || (u.Scheme == "" && strings.HasPrefix(u.Path, "/"))) {
+			errs.Add([]string{"redirect"}, "ValueError", fmt.Sprintf("invalid redirect-[%s]", rp.Redirect))
+		}
+	}
+
+	return errs
+}
+
+func (rp *RuleParam) Rule() *product.Rule {
+	trim := func(input []string) []string {
+		result := make([]string, 0, len(input))
+		for _, v := range input {
+			if v = strings.TrimSpace(v); v != "" {
+				result = append(result, v)
+			}
+		}
+		return result
+	}
+
+	result := &product.Rule{
+		Query:    rp.Query,
+		Match:    rp.Match,
+		Pin:      trim(rp.Pin),
+		Bury:     trim(rp.Bury),
+		Redirect: rp.Redirect,
+		Label:    strings.TrimSpace(rp.Label),
+		Enabled:  rp.Enabled == nil || *rp.Enabled,
+	}
+	if result.Match == "" {
+		result.Match = product.RuleMatchExact
+	}
+
+	return result
+}
+
+func (h *Handler) Rules(w http.ResponseWriter, r *http.Request) {
+	data, err := h.uc.Rules(r.Context())
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	common.Render().JSON(w, http.StatusOK, data)
+}
+
+func (h *Handler) CreateRule(w http.ResponseWriter, r *http.Request) {
+	rp := RuleParam{}
+	if err := binding.Bind(r, &rp); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	rule := rp.Rule()
+	if err := h.uc.CreateRule(r.Context(), rule); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	common.Render().JSON(w, http.StatusOK, rule)
+}
+
+func (h *Handler) UpdateRule(w http.ResponseWriter, r *http.Request) {
+	rp := RuleParam{}
+	if err := binding.Bind(r, &rp); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	rule := rp.Rule()
+	rule.Id = mux.Vars(r)["ruleId"]
+	if err := h.uc.UpdateRule(r.Context(), rule); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	common.Render().JSON(w, http.StatusOK, rule)
+}
+
+func (h *Handler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	if err := h.uc.DeleteRule(r.Context(), mux.Vars(r)["ruleId"]); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
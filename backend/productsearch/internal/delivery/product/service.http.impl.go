@@ -2,8 +2,10 @@ package product
 
 import (
 	"context"
+	"errors"
 	"github.com/ringbrew/gsv/service"
 	"github.com/ringbrew/newaim/productsearch/internal/domain"
+	"github.com/ringbrew/newaim/productsearch/internal/domain/apikey"
 	"github.com/ringbrew/newaim/productsearch/internal/domain/product"
 	"log"
 )
@@ -16,7 +18,7 @@ type Service struct {
 	desc   service.Description
 }
 
-func NewService(ctx *domain.UseCaseContext) service.Service {
+func NewService(ctx *domain.UseCaseContext, keys *apikey.UseCase) service.Service {
 	s := &Service{
 		ctx:    ctx,
 		name:   "product",
@@ -34,18 +36,22 @@ func NewService(ctx *domain.UseCaseContext) service.Service {
 			log.Fatal(err.Error())
 		}
 
-		if ctx.Config.ForceRebuild {
-			if err := uc.Rebuild(context.Background()); err != nil {
+		if err := uc.Rebuild(context.Background(), data); err != nil {
+			var bce *product.BatchCreateError
+			if !errors.As(err, &bce) {
 				log.Fatal(err.Error())
 			}
-		}
 
-		if err := uc.BatchCreate(context.Background(), data); err != nil {
-			log.Fatal(err.Error())
+			for _, v := range bce.Failed {
+				log.Printf("product-[%s] sku-[%s] embed fail: %s", v.Id, v.SKU, v.Err.Error())
+			}
 		}
+	} else if err := uc.LoadMemoryStore(context.Background()); err != nil {
+		// the lexical search still works, only the vector search misses the products.
+		log.Printf("load memory vector store fail: %s", err.Error())
 	}
 
-	handler := NewHandler(ctx, uc)
+	handler := NewHandler(ctx, uc, keys)
 	s.desc.HttpRoute = append(s.desc.HttpRoute, handler.HttpRoute()...)
 	return s
 }
@@ -0,0 +1,128 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeSearchRead   = "search:read"
+	ScopeCatalogWrite = "catalog:write"
+	// ScopeAdmin grants every scope.
+	ScopeAdmin = "admin"
+)
+
+var (
+	ErrInvalidScope = errors.New("invalid scope")
+	ErrInvalidTier  = errors.New("invalid tier")
+	ErrKeyNotFound  = errors.New("api key not found")
+	ErrInvalidKey   = errors.New("invalid api key")
+	ErrKeyExpired   = errors.New("api key expired")
+	ErrKeyRevoked   = errors.New("api key revoked")
+	// ErrStoreUnavailable the key can not be verified while redis fails.
+	ErrStoreUnavailable = errors.New("api key store unavailable")
+)
+
+/*
+@desc: an issued api key, the secret is only returned once on issue and rotate, the store keeps its hash.
+the key sent by the client is <id>.<secret>.
+*/
+type ApiKey struct {
+	Id     string   `json:"id"`
+	Owner  string   `json:"owner"`
+	Scopes []string `json:"scopes"`
+	// Tier of the rate limit, see the rateLimit tiers of the config.
+	Tier       string     `json:"tier,omitempty"`
+	Hash       string     `json:"hash,omitempty"`
+	CreateTime time.Time  `json:"createTime"`
+	UpdateTime time.Time  `json:"updateTime"`
+	ExpireTime *time.Time `json:"expireTime,omitempty"`
+	RevokeTime *time.Time `json:"revokeTime,omitempty"`
+	// PreviousHash of a rotated key, valid until PreviousExpireTime.
+	PreviousHash       string     `json:"previousHash,omitempty"`
+	PreviousExpireTime *time.Time `json:"previousExpireTime,omitempty"`
+}
+
+/*
+@desc: the api key without hashes, for the admin endpoints.
+*/
+func (k ApiKey) View() ApiKey {
+	k.Hash = ""
+	k.PreviousHash = ""
+	return k
+}
+
+/*
+@desc: whether the key grants the scope, a key stored without scopes can only search, as issued by default.
+*/
+func (k *ApiKey) HasScope(scope string) bool {
+	if len(k.Scopes) == 0 {
+		return scope == ScopeSearchRead
+	}
+
+	for _, v := range k.Scopes {
+		if v == scope || v == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeSearchRead, ScopeCatalogWrite, ScopeAdmin:
+		return true
+	default:
+		return false
+	}
+}
+
+func (k *ApiKey) check(secret string, now time.Time) error {
+	if k.RevokeTime != nil {
+		return ErrKeyRevoked
+	}
+
+	if k.ExpireTime != nil && !now.Before(*k.ExpireTime) {
+		return ErrKeyExpired
+	}
+
+	h := hashSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(h), []byte(k.Hash)) == 1 {
+		return nil
+	}
+
+	// the old secret keeps working during the grace of a rotation.
+	if k.PreviousHash != "" && k.PreviousExpireTime != nil && now.Before(*k.PreviousExpireTime) &&
+		subtle.ConstantTimeCompare([]byte(h), []byte(k.PreviousHash)) == 1 {
+		return nil
+	}
+
+	return ErrInvalidKey
+}
+
+// the secret is random, a plain sha256 is enough.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func parseKey(key string) (string, string, error) {
+	id, secret, found := strings.Cut(key, ".")
+	if !found || id == "" || secret == "" {
+		return "", "", ErrInvalidKey
+	}
+	return id, secret, nil
+}
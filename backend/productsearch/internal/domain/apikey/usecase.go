@@ -0,0 +1,282 @@
+package apikey
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/ringbrew/newaim/productsearch/internal/domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	apiKeyKey = "newaim_product_apikey"
+
+	// validated keys are cached, a revoke from another instance applies within the ttl.
+	cacheTTL = 30 * time.Second
+
+	// a cached key stands in for redis this long past its ttl, a revoke applies after it even if redis stays down.
+	staleTTL = 5 * time.Minute
+
+	// same as the fallback of the rate limit config.
+	fallbackOpen = "open"
+
+	// UnverifiedId of the key accepted unchecked while redis fails, the rate limit counts it by client ip.
+	UnverifiedId = "unverified"
+)
+
+type repo struct {
+	rds *redis.Client
+}
+
+func (r *repo) List(ctx context.Context) ([]ApiKey, error) {
+	data, err := r.rds.HGetAll(ctx, apiKeyKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ApiKey, 0, len(data))
+	for _, v := range data {
+		var k ApiKey
+		if err := json.Unmarshal([]byte(v), &k); err != nil {
+			return nil, err
+		}
+		result = append(result, k)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreateTime.Before(result[j].CreateTime)
+	})
+
+	return result, nil
+}
+
+func (r *repo) Get(ctx context.Context, id string) (*ApiKey, error) {
+	data, err := r.rds.HGet(ctx, apiKeyKey, id).Result()
+	if err == redis.Nil {
+		return nil, ErrKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var k ApiKey
+	if err := json.Unmarshal([]byte(data), &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (r *repo) Save(ctx context.Context, k *ApiKey) error {
+	data, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return r.rds.HSet(ctx, apiKeyKey, k.Id, data).Err()
+}
+
+type cacheEntry struct {
+	key    ApiKey
+	expire time.Time
+}
+
+type UseCase struct {
+	repo *repo
+	now  func() time.Time
+	// fallback of a key not cached while redis fails, see Validate.
+	fallback string
+	// tiers of the rate limit config, a key is issued in one of them.
+	tiers map[string]bool
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+/*
+@desc: the services should share one use case, a revoke evicts the key from its cache only.
+*/
+func NewUseCase(ctx *domain.UseCaseContext) *UseCase {
+	uc := newUseCase(ctx.Redis)
+	uc.fallback = ctx.Config.RateLimit.Fallback
+	for name := range ctx.Config.RateLimit.Tiers {
+		uc.tiers[name] = true
+	}
+	return uc
+}
+
+func newUseCase(rds *redis.Client) *UseCase {
+	return &UseCase{
+		repo:  &repo{rds: rds},
+		now:   time.Now,
+		tiers: make(map[string]bool),
+		cache: make(map[string]cacheEntry),
+	}
+}
+
+type IssueRequest struct {
+	Owner      string
+	Scopes     []string
+	Tier       string
+	ExpireTime *time.Time
+}
+
+/*
+@desc: issue a new api key, return the key with the secret to hand to the client.
+the key gets search:read when the request has no scope.
+*/
+func (uc *UseCase) Issue(ctx context.Context, req IssueRequest) (*ApiKey, string, error) {
+	for _, v := range req.Scopes {
+		if !ValidScope(v) {
+			return nil, "", fmt.Errorf("%w: scope-[%s]", ErrInvalidScope, v)
+		}
+	}
+	if req.Tier != "" && !uc.tiers[req.Tier] {
+		return nil, "", fmt.Errorf("%w: tier-[%s]", ErrInvalidTier, req.Tier)
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := uc.now()
+	k := &ApiKey{
+		Id:         primitive.NewObjectID().Hex(),
+		Owner:      req.Owner,
+		Scopes:     req.Scopes,
+		Tier:       req.Tier,
+		Hash:       hashSecret(secret),
+		CreateTime: now,
+		UpdateTime: now,
+		ExpireTime: req.ExpireTime,
+	}
+	// a key issued without scope can search.
+	if len(k.Scopes) == 0 {
+		k.Scopes = []string{ScopeSearchRead}
+	}
+
+	if err := uc.repo.Save(ctx, k); err != nil {
+		return nil, "", err
+	}
+
+	return k, k.Id + "." + secret, nil
+}
+
+func (uc *UseCase) List(ctx context.Context) ([]ApiKey, error) {
+	return uc.repo.List(ctx)
+}
+
+/*
+@desc: replace the secret of the key, the old secret keeps working for the grace.
+*/
+func (uc *UseCase) Rotate(ctx context.Context, id string, grace time.Duration) (*ApiKey, string, error) {
+	k, err := uc.repo.Get(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if k.RevokeTime != nil {
+		return nil, "", ErrKeyRevoked
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := uc.now()
+	k.PreviousHash = ""
+	k.PreviousExpireTime = nil
+	if grace > 0 {
+		expire := now.Add(grace)
+		k.PreviousHash = k.Hash
+		k.PreviousExpireTime = &expire
+	}
+	k.Hash = hashSecret(secret)
+	k.UpdateTime = now
+
+	if err := uc.repo.Save(ctx, k); err != nil {
+		return nil, "", err
+	}
+	uc.evict(id)
+
+	return k, k.Id + "." + secret, nil
+}
+
+func (uc *UseCase) Revoke(ctx context.Context, id string) (*ApiKey, error) {
+	k, err := uc.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.RevokeTime == nil {
+		now := uc.now()
+		k.RevokeTime = &now
+		k.UpdateTime = now
+
+		if err := uc.repo.Save(ctx, k); err != nil {
+			return nil, err
+		}
+	}
+	uc.evict(id)
+
+	return k, nil
+}
+
+/*
+@desc: validate the key sent by the client. while redis fails the last good copy of the key is used
+up to staleTTL, past it or for a key never cached ErrStoreUnavailable is returned, or when the rate limit
+fallback is open, an unverified key which can only search.
+*/
+func (uc *UseCase) Validate(ctx context.Context, key string) (*ApiKey, error) {
+	id, secret, err := parseKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	now := uc.now()
+
+	uc.mu.Lock()
+	entry, cached := uc.cache[id]
+	uc.mu.Unlock()
+
+	if !cached || !now.Before(entry.expire) {
+		k, err := uc.repo.Get(ctx, id)
+		if err == ErrKeyNotFound {
+			uc.evict(id)
+			return nil, err
+		} else if err != nil {
+			log.Printf("api key store fail: %s", err.Error())
+			if !cached || !now.Before(entry.expire.Add(staleTTL)) {
+				// the id is the choice of the caller, the unverified key must not be told apart by it.
+				if uc.fallback == fallbackOpen {
+					return &ApiKey{Id: UnverifiedId, Scopes: []string{ScopeSearchRead}}, nil
+				}
+				return nil, ErrStoreUnavailable
+			}
+		} else {
+			entry = cacheEntry{key: *k, expire: now.Add(cacheTTL)}
+			uc.mu.Lock()
+			uc.cache[id] = entry
+			uc.mu.Unlock()
+		}
+	}
+
+	k := entry.key
+	if err := k.check(secret, now); err != nil {
+		return nil, err
+	}
+	if len(k.Scopes) == 0 {
+		k.Scopes = []string{ScopeSearchRead}
+	}
+	return &k, nil
+}
+
+func (uc *UseCase) evict(id string) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	delete(uc.cache, id)
+}
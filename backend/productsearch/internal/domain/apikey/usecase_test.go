@@ -0,0 +1,137 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApiKey(t *testing.T) {
+	mr := miniredis.RunT(t)
+	uc := newUseCase(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	ctx := context.Background()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	uc.now = func() time.Time { return now }
+	uc.tiers["premium"] = true
+
+	if _, _, err := uc.Issue(ctx, IssueRequest{Owner: "shop", Tier: "gold"}); !errors.Is(err, ErrInvalidTier) {
+		t.Errorf("unknown tier should be rejected: %v", err)
+	}
+
+	expire := now.Add(time.Hour)
+	k, key, err := uc.Issue(ctx, IssueRequest{Owner: "shop", Scopes: []string{"search:read"}, Tier: "premium", ExpireTime: &expire})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, k.Id+".") || strings.Contains(mr.HGet(apiKeyKey, k.Id), strings.TrimPrefix(key, k.Id+".")) {
+		t.Fatalf("the store must only keep the hash: %s", key)
+	}
+
+	if v, err := uc.Validate(ctx, key); err != nil || v.Owner != "shop" || v.Tier != "premium" || !v.HasScope("search:read") {
+		t.Fatalf("unexpected validate: %+v %v", v, err)
+	}
+
+	invalid := []string{"", "nodot", k.Id + ".wrong", "000000000000000000000000.secret"}
+	for _, v := range invalid {
+		if _, err := uc.Validate(ctx, v); err != ErrInvalidKey && err != ErrKeyNotFound {
+			t.Errorf("key-[%s] should be rejected: %v", v, err)
+		}
+	}
+
+	// the old secret works during the grace only.
+	_, rotated, err := uc.Rotate(ctx, k.Id, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uc.Validate(ctx, key); err != nil {
+		t.Errorf("old secret should work in the grace: %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := uc.Validate(ctx, key); err != ErrInvalidKey {
+		t.Errorf("old secret should be rejected after the grace: %v", err)
+	}
+	if _, err := uc.Validate(ctx, rotated); err != nil {
+		t.Errorf("rotated secret should work: %v", err)
+	}
+
+	// a redis failure keeps the cached key.
+	mr.SetError("ERR redis down")
+	now = now.Add(time.Minute)
+	if _, err := uc.Validate(ctx, rotated); err != nil {
+		t.Errorf("cached key should work while redis fails: %v", err)
+	}
+	now = now.Add(staleTTL)
+	if _, err := uc.Validate(ctx, rotated); err != ErrStoreUnavailable {
+		t.Errorf("cached key should not outlive the stale ttl: %v", err)
+	}
+	mr.SetError("")
+
+	now = now.Add(time.Hour)
+	if _, err := uc.Validate(ctx, rotated); err != ErrKeyExpired {
+		t.Errorf("expired key should be rejected: %v", err)
+	}
+
+	if _, err := uc.Revoke(ctx, k.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uc.Validate(ctx, rotated); err != ErrKeyRevoked {
+		t.Errorf("revoked key should be rejected: %v", err)
+	}
+	if _, _, err := uc.Rotate(ctx, k.Id, 0); err != ErrKeyRevoked {
+		t.Errorf("revoked key should not rotate: %v", err)
+	}
+}
+
+func TestValidateUncached(t *testing.T) {
+	mr := miniredis.RunT(t)
+	uc := newUseCase(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	ctx := context.Background()
+
+	_, key, err := uc.Issue(ctx, IssueRequest{Owner: "shop", Scopes: []string{ScopeCatalogWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// never validated, so not cached when redis fails.
+	mr.SetError("ERR redis down")
+	if _, err := uc.Validate(ctx, key); err != ErrStoreUnavailable {
+		t.Errorf("uncached key should be unavailable: %v", err)
+	}
+
+	uc.fallback = fallbackOpen
+	k, err := uc.Validate(ctx, key)
+	if err != nil || !k.HasScope(ScopeSearchRead) || k.HasScope(ScopeCatalogWrite) || k.Id != UnverifiedId {
+		t.Errorf("open fallback should only search: %+v %v", k, err)
+	}
+	// the id sent by the caller does not make another key.
+	if k, err := uc.Validate(ctx, "other.secret"); err != nil || k.Id != UnverifiedId {
+		t.Errorf("unverified keys should share one id: %+v %v", k, err)
+	}
+}
+
+func TestValidateWithoutScopes(t *testing.T) {
+	mr := miniredis.RunT(t)
+	uc := newUseCase(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	ctx := context.Background()
+
+	// saved before the keys had scopes.
+	if err := uc.repo.Save(ctx, &ApiKey{Id: "legacy", Owner: "shop", Scopes: []string{}, Hash: hashSecret("secret")}); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := uc.Validate(ctx, "legacy.secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !k.HasScope(ScopeSearchRead) || k.HasScope(ScopeCatalogWrite) || k.HasScope(ScopeAdmin) {
+		t.Errorf("key without scopes should only search: %+v", k)
+	}
+	if !(&ApiKey{}).HasScope(ScopeSearchRead) {
+		t.Error("empty scopes should grant search:read")
+	}
+}
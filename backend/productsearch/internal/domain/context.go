@@ -43,9 +43,10 @@ func NewUseCaseContext(c conf.Config) *UseCaseContext {
 			Password: c.Redis.Password,
 		})
 
-		//if err := dsc.Redis.Ping(context.Background()).Err(); err != nil {
-		//	log.Fatal(err.Error())
-		//}
+		// not fatal, the rate limit falls back while redis is unavailable.
+		if err := dsc.Redis.Ping(context.Background()).Err(); err != nil {
+			log.Printf("redis unavailable: %s", err.Error())
+		}
 
 		esClient, err := elasticsearch.NewClient(elasticsearch.Config{
 			Addresses: c.ElasticSearch.Address,
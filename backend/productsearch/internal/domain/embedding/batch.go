@@ -0,0 +1,225 @@
+package embedding
+
+import (
+	"context"
+	"fmt"
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultBatchDocuments   = 256
+	defaultBatchTokens      = 8000
+	defaultBatchConcurrency = 2
+	defaultBatchRetries     = 3
+	defaultBatchBackoff     = 500 * time.Millisecond
+	maxBatchBackoff         = 30 * time.Second
+)
+
+/*
+@desc: one request worth of documents, Index holds the position of each document in the whole input.
+*/
+type Batch struct {
+	Index     []int
+	Documents []string
+	Data      []Data
+}
+
+type BatchFailure struct {
+	Index []int
+	Err   error
+}
+
+type BatchProgress struct {
+	Batches   int
+	Done      int
+	Failed    int
+	Documents int
+	Embedded  int
+}
+
+type BatchResult struct {
+	Usage    Usage
+	Failures []BatchFailure
+}
+
+type BatchHandler func(ctx context.Context, batch Batch) error
+
+/*
+@desc: Batcher splits a large document list by count and estimated tokens,
+embeds the batches with bounded concurrency and retries failed batches with exponential backoff.
+*/
+type Batcher struct {
+	em          Embedding
+	maxDocs     int
+	maxTokens   int
+	concurrency int
+	maxRetries  int
+	backoff     time.Duration
+	progress    func(p BatchProgress)
+}
+
+func NewBatcher(em Embedding, c conf.EmbeddingBatch) *Batcher {
+	b := &Batcher{
+		em:          em,
+		maxDocs:     c.MaxDocuments,
+		maxTokens:   c.MaxTokens,
+		concurrency: c.Concurrency,
+		maxRetries:  c.MaxRetries,
+		backoff:     time.Duration(c.BackoffMs) * time.Millisecond,
+	}
+
+	if b.maxDocs <= 0 {
+		b.maxDocs = defaultBatchDocuments
+	}
+	if b.maxTokens <= 0 {
+		b.maxTokens = defaultBatchTokens
+	}
+	if b.concurrency <= 0 {
+		b.concurrency = defaultBatchConcurrency
+	}
+	if b.maxRetries < 0 {
+		b.maxRetries = 0
+	} else if b.maxRetries == 0 {
+		b.maxRetries = defaultBatchRetries
+	}
+	if b.backoff <= 0 {
+		b.backoff = defaultBatchBackoff
+	}
+
+	return b
+}
+
+func (b *Batcher) WithProgress(progress func(p BatchProgress)) *Batcher {
+	b.progress = progress
+	return b
+}
+
+/*
+@desc: embed every document, handle is called once for each embedded batch.
+a batch failing to embed, or failing in handle, is reported in the result instead of stopping the others.
+*/
+func (b *Batcher) Embed(ctx context.Context, documents []string, handle BatchHandler) BatchResult {
+	batches := b.split(documents)
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		result   BatchResult
+		progress = BatchProgress{Batches: len(batches), Documents: len(documents)}
+		sem      = make(chan struct{}, b.concurrency)
+	)
+
+	for i := range batches {
+		batch := batches[i]
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			usage, err := b.embed(ctx, &batch)
+			if err == nil && handle != nil {
+				err = handle(ctx, batch)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			result.Usage.PromptTokens += usage.PromptTokens
+			result.Usage.CompletionTokens += usage.CompletionTokens
+			result.Usage.TotalTokens += usage.TotalTokens
+
+			if err != nil {
+				result.Failures = append(result.Failures, BatchFailure{
+					Index: batch.Index,
+					Err:   err,
+				})
+				progress.Failed++
+			} else {
+				progress.Done++
+				progress.Embedded += len(batch.Index)
+			}
+
+			if b.progress != nil {
+				b.progress(progress)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return result
+}
+
+func (b *Batcher) embed(ctx context.Context, batch *Batch) (Usage, error) {
+	var err error
+	for attempt := 0; attempt <= b.maxRetries; attempt++ {
+		if attempt > 0 {
+			wait := b.backoff << (attempt - 1)
+			if wait > maxBatchBackoff {
+				wait = maxBatchBackoff
+			}
+
+			select {
+			case <-ctx.Done():
+				return Usage{}, ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		var resp DocumentResponse
+		resp, err = b.em.EmbedDocument(ctx, DocumentRequest{Documents: batch.Documents})
+		if err != nil {
+			continue
+		}
+
+		if len(resp.Data) != len(batch.Documents) {
+			err = fmt.Errorf("embedding response size mismatch, want %d got %d", len(batch.Documents), len(resp.Data))
+			continue
+		}
+
+		batch.Data = resp.Data
+		return resp.Usage, nil
+	}
+
+	return Usage{}, err
+}
+
+func (b *Batcher) split(documents []string) []Batch {
+	result := make([]Batch, 0, len(documents)/b.maxDocs+1)
+
+	current := Batch{}
+	tokens := 0
+	for i, v := range documents {
+		t := EstimateTokens(v)
+
+		if len(current.Index) > 0 && (len(current.Index) >= b.maxDocs || tokens+t > b.maxTokens) {
+			result = append(result, current)
+			current = Batch{}
+			tokens = 0
+		}
+
+		current.Index = append(current.Index, i)
+		current.Documents = append(current.Documents, v)
+		tokens += t
+	}
+
+	if len(current.Index) > 0 {
+		result = append(result, current)
+	}
+
+	return result
+}
+
+/*
+@desc: rough token count, about 4 characters a token for english text.
+*/
+func EstimateTokens(content string) int {
+	return utf8.RuneCountInString(content)/4 + 1
+}
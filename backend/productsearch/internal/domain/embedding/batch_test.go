@@ -0,0 +1,71 @@
+package embedding
+
+import (
+	"context"
+	"errors"
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type flakyEmbedding struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+// fails twice on every batch, and always on a batch containing "broken".
+func (f *flakyEmbedding) EmbedDocument(ctx context.Context, req DocumentRequest) (DocumentResponse, error) {
+	f.mu.Lock()
+	key := strings.Join(req.Documents, "|")
+	f.calls[key]++
+	calls := f.calls[key]
+	f.mu.Unlock()
+
+	if calls <= 2 || strings.Contains(key, "broken") {
+		return DocumentResponse{}, errors.New("rate limited")
+	}
+
+	return newHashing(8).EmbedDocument(ctx, req)
+}
+
+func (f *flakyEmbedding) EmbedSingle(ctx context.Context, req SingleRequest) (SingleResponse, error) {
+	return newHashing(8).EmbedSingle(ctx, req)
+}
+
+func TestBatcher(t *testing.T) {
+	documents := []string{"a", "b", "c", "broken", "e", strings.Repeat("long ", 100)}
+
+	batcher := NewBatcher(&flakyEmbedding{calls: make(map[string]int)}, conf.EmbeddingBatch{
+		MaxDocuments: 2,
+		MaxTokens:    50,
+		Concurrency:  2,
+		MaxRetries:   2,
+		BackoffMs:    1,
+	})
+
+	var mu sync.Mutex
+	embedded := make(map[int]bool)
+	result := batcher.Embed(context.Background(), documents, func(ctx context.Context, batch Batch) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, v := range batch.Data {
+			embedded[batch.Index[v.Index]] = true
+		}
+		return nil
+	})
+
+	if len(result.Failures) != 1 {
+		t.Fatalf("unexpected failures: %v", result.Failures)
+	}
+
+	if f := result.Failures[0].Index; len(f) != 2 || f[0] != 2 || f[1] != 3 {
+		t.Errorf("failure should be attributed to the batch of broken: %v", f)
+	}
+
+	for _, i := range []int{0, 1, 4, 5} {
+		if !embedded[i] {
+			t.Errorf("document %d should be embedded", i)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package embedding
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"log"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+const defaultCacheTTL = 30 * 24 * time.Hour
+
+type CacheStats struct {
+	Hit  int64 `json:"hit"`
+	Miss int64 `json:"miss"`
+}
+
+/*
+@desc: Cache decorates an embedding with a redis cache keyed by model alias and content hash,
+redis errors are logged and the call goes to the decorated embedding.
+*/
+type Cache struct {
+	em    Embedding
+	rds   *redis.Client
+	alias string
+	ttl   time.Duration
+	hit   int64
+	miss  int64
+}
+
+func NewCache(em Embedding, rds *redis.Client, alias string, ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+
+	return &Cache{
+		em:    em,
+		rds:   rds,
+		alias: alias,
+		ttl:   ttl,
+	}
+}
+
+func (c *Cache) Stats() CacheStats {
+	return CacheStats{
+		Hit:  atomic.LoadInt64(&c.hit),
+		Miss: atomic.LoadInt64(&c.miss),
+	}
+}
+
+func (c *Cache) EmbedDocument(ctx context.Context, req DocumentRequest) (DocumentResponse, error) {
+	keys := make([]string, len(req.Documents))
+	for i, v := range req.Documents {
+		keys[i] = c.key(v)
+	}
+
+	cached, err := c.rds.MGet(ctx, keys...).Result()
+	if err != nil {
+		log.Printf("embedding cache mget fail: %s", err.Error())
+		cached = make([]interface{}, len(keys))
+	}
+
+	result := DocumentResponse{
+		Data: make([]Data, 0, len(req.Documents)),
+	}
+
+	missIndex := make([]int, 0)
+	missDocuments := make([]string, 0)
+	for i, v := range cached {
+		if s, ok := v.(string); ok {
+			if vector, ok := decodeVector(s); ok {
+				result.Data = append(result.Data, Data{
+					Content: req.Documents[i],
+					Vector:  vector,
+					Index:   i,
+				})
+				continue
+			}
+		}
+		missIndex = append(missIndex, i)
+		missDocuments = append(missDocuments, req.Documents[i])
+	}
+
+	atomic.AddInt64(&c.hit, int64(len(result.Data)))
+	atomic.AddInt64(&c.miss, int64(len(missIndex)))
+
+	if len(missIndex) == 0 {
+		return result, nil
+	}
+
+	resp, err := c.em.EmbedDocument(ctx, DocumentRequest{Documents: missDocuments})
+	if err != nil {
+		return DocumentResponse{}, err
+	}
+
+	pipe := c.rds.Pipeline()
+	for _, v := range resp.Data {
+		if v.Index < 0 || v.Index >= len(missIndex) {
+			return DocumentResponse{}, fmt.Errorf("invalid embedding response index %d", v.Index)
+		}
+
+		i := missIndex[v.Index]
+		v.Index = i
+		result.Data = append(result.Data, v)
+		pipe.Set(ctx, keys[i], encodeVector(v.Vector), c.ttl)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("embedding cache set fail: %s", err.Error())
+	}
+
+	result.Usage = resp.Usage
+	return result, nil
+}
+
+func (c *Cache) EmbedSingle(ctx context.Context, req SingleRequest) (SingleResponse, error) {
+	key := c.key(req.Content)
+
+	s, err := c.rds.Get(ctx, key).Result()
+	if err != nil && err != redis.Nil {
+		log.Printf("embedding cache get fail: %s", err.Error())
+	}
+
+	if err == nil {
+		if vector, ok := decodeVector(s); ok {
+			atomic.AddInt64(&c.hit, 1)
+			return SingleResponse{
+				Data: Data{
+					Content: req.Content,
+					Vector:  vector,
+				},
+			}, nil
+		}
+	}
+
+	atomic.AddInt64(&c.miss, 1)
+
+	resp, err := c.em.EmbedSingle(ctx, req)
+	if err != nil {
+		return SingleResponse{}, err
+	}
+
+	if err := c.rds.Set(ctx, key, encodeVector(resp.Data.Vector), c.ttl).Err(); err != nil {
+		log.Printf("embedding cache set fail: %s", err.Error())
+	}
+
+	return resp, nil
+}
+
+func (c *Cache) key(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return fmt.Sprintf("newaim_embedding_cache_%s_%s", c.alias, hex.EncodeToString(sum[:]))
+}
+
+func encodeVector(v Vector) string {
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return string(buf)
+}
+
+func decodeVector(s string) (Vector, bool) {
+	if len(s) == 0 || len(s)%4 != 0 {
+		return nil, false
+	}
+
+	result := make(Vector, len(s)/4)
+	for i := range result {
+		result[i] = math.Float32frombits(binary.LittleEndian.Uint32([]byte(s[4*i : 4*i+4])))
+	}
+	return result, true
+}
@@ -0,0 +1,73 @@
+package embedding
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type countingEmbedding struct {
+	documents []string
+}
+
+func (c *countingEmbedding) EmbedDocument(ctx context.Context, req DocumentRequest) (DocumentResponse, error) {
+	c.documents = append(c.documents, req.Documents...)
+	return newHashing(8).EmbedDocument(ctx, req)
+}
+
+func (c *countingEmbedding) EmbedSingle(ctx context.Context, req SingleRequest) (SingleResponse, error) {
+	c.documents = append(c.documents, req.Content)
+	return newHashing(8).EmbedSingle(ctx, req)
+}
+
+func TestCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	em := &countingEmbedding{}
+	c := NewCache(em, redis.NewClient(&redis.Options{Addr: mr.Addr()}), "test", time.Hour)
+	ctx := context.Background()
+
+	if _, err := c.EmbedSingle(ctx, SingleRequest{Content: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	single, err := c.EmbedSingle(ctx, SingleRequest{Content: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := newHashing(8).EmbedSingle(ctx, SingleRequest{Content: "a"}); !reflect.DeepEqual(single.Data.Vector, want.Data.Vector) {
+		t.Errorf("cached vector differs: %v", single.Data.Vector)
+	}
+	if stats := c.Stats(); stats.Hit != 1 || stats.Miss != 1 {
+		t.Errorf("unexpected stats after single: %+v", stats)
+	}
+
+	// a partial batch only embeds the missing documents, in the order of the request.
+	resp, err := c.EmbedDocument(ctx, DocumentRequest{Documents: []string{"b", "a", "c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(em.documents, []string{"a", "b", "c"}) {
+		t.Errorf("unexpected embedded documents: %v", em.documents)
+	}
+	if len(resp.Data) != 3 {
+		t.Fatalf("unexpected batch size: %d", len(resp.Data))
+	}
+	for _, v := range resp.Data {
+		want, _ := newHashing(8).EmbedSingle(ctx, SingleRequest{Content: v.Content})
+		if []string{"b", "a", "c"}[v.Index] != v.Content || !reflect.DeepEqual(v.Vector, want.Data.Vector) {
+			t.Errorf("unexpected data of %s at %d", v.Content, v.Index)
+		}
+	}
+	if stats := c.Stats(); stats.Hit != 2 || stats.Miss != 3 {
+		t.Errorf("unexpected stats after batch: %+v", stats)
+	}
+
+	if _, err := c.EmbedDocument(ctx, DocumentRequest{Documents: []string{"c", "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if stats := c.Stats(); stats.Hit != 4 || stats.Miss != 3 || len(em.documents) != 3 {
+		t.Errorf("a cached batch should not embed: %+v %v", stats, em.documents)
+	}
+}
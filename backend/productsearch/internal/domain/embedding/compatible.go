@@ -0,0 +1,158 @@
+package embedding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
+	"github.com/ringbrew/newaim/productsearch/internal/domain"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+@desc: Compatible talks to any server implementing the openai /embeddings api,
+e.g. openai itself for models unknown to the sdk, ollama, vllm or localai.
+*/
+type Compatible struct {
+	endpoint   string
+	token      string
+	model      string
+	dimensions int
+	client     *http.Client
+}
+
+func newCompatibleProvider(ctx *domain.UseCaseContext, c conf.Embedding) (Embedding, error) {
+	return newCompatible(c)
+}
+
+func newCompatible(c conf.Embedding) (*Compatible, error) {
+	if c.Endpoint == "" || c.Model == "" {
+		return nil, errors.New("invalid openai compatible config")
+	}
+
+	return &Compatible{
+		endpoint:   strings.TrimSuffix(c.Endpoint, "/"),
+		token:      c.Token,
+		model:      c.Model,
+		dimensions: c.Dimensions,
+		client: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+	}, nil
+}
+
+type compatibleRequest struct {
+	Input      []string `json:"input"`
+	Model      string   `json:"model"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+type compatibleResponse struct {
+	Data []struct {
+		Object    string    `json:"object"`
+		Embedding []float32 `json:"embedding"`
+		Index     int       `json:"index"`
+	} `json:"data"`
+	Usage struct {
+		PromptTokens int `json:"prompt_tokens"`
+		TotalTokens  int `json:"total_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (c *Compatible) EmbedDocument(ctx context.Context, req DocumentRequest) (DocumentResponse, error) {
+	resp, err := c.embed(ctx, req.Documents)
+	if err != nil {
+		return DocumentResponse{}, err
+	}
+
+	result := DocumentResponse{
+		Data: make([]Data, 0, len(resp.Data)),
+		Usage: Usage{
+			PromptTokens: resp.Usage.PromptTokens,
+			TotalTokens:  resp.Usage.TotalTokens,
+		},
+	}
+
+	for _, v := range resp.Data {
+		result.Data = append(result.Data, Data{
+			Content: v.Object,
+			Vector:  v.Embedding,
+			Index:   v.Index,
+		})
+	}
+
+	return result, nil
+}
+
+func (c *Compatible) EmbedSingle(ctx context.Context, req SingleRequest) (SingleResponse, error) {
+	resp, err := c.embed(ctx, []string{req.Content})
+	if err != nil {
+		return SingleResponse{}, err
+	}
+
+	if len(resp.Data) == 0 {
+		return SingleResponse{}, errors.New("invalid embedding response")
+	}
+
+	return SingleResponse{
+		Data: Data{
+			Content: resp.Data[0].Object,
+			Vector:  resp.Data[0].Embedding,
+			Index:   resp.Data[0].Index,
+		},
+		Usage: Usage{
+			PromptTokens: resp.Usage.PromptTokens,
+			TotalTokens:  resp.Usage.TotalTokens,
+		},
+	}, nil
+}
+
+func (c *Compatible) embed(ctx context.Context, input []string) (compatibleResponse, error) {
+	var result compatibleResponse
+
+	body, err := json.Marshal(compatibleRequest{
+		Input:      input,
+		Model:      c.model,
+		Dimensions: c.dimensions,
+	})
+	if err != nil {
+		return result, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return result, fmt.Errorf("error parsing the embedding response, status[%s]: %s", resp.Status, err.Error())
+	}
+
+	if result.Error != nil {
+		return result, fmt.Errorf("error embedding, status[%s]: %s", resp.Status, result.Error.Message)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("error embedding, status[%s]", resp.Status)
+	}
+
+	return result, nil
+}
@@ -3,7 +3,10 @@ package embedding
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
 	"github.com/ringbrew/newaim/productsearch/internal/domain"
+	"time"
 )
 
 type Embedding interface {
@@ -11,8 +14,72 @@ type Embedding interface {
 	EmbedSingle(ctx context.Context, req SingleRequest) (SingleResponse, error)
 }
 
-func NewEmbedding(ctx *domain.UseCaseContext, alias string) (Embedding, error) {
-	return newOpenAI(ctx, alias)
+const (
+	ProviderOpenAI           = "openai"
+	ProviderOpenAICompatible = "openai-compatible"
+	ProviderHashing          = "hashing"
+)
+
+var ErrNotConfigured = errors.New("embedding not configured")
+
+type Factory func(ctx *domain.UseCaseContext, c conf.Embedding) (Embedding, error)
+
+var providers = map[string]Factory{
+	ProviderOpenAI:           newOpenAIProvider,
+	ProviderOpenAICompatible: newCompatibleProvider,
+	ProviderHashing:          newHashingProvider,
+}
+
+/*
+@desc: register a provider factory, the provider name is the key used by config.
+*/
+func Register(provider string, factory Factory) {
+	providers[provider] = factory
+}
+
+/*
+@desc: new embedding of the configured provider, return ErrNotConfigured when no provider is configured.
+*/
+func NewEmbedding(ctx *domain.UseCaseContext) (Embedding, error) {
+	c := ctx.Config.Embedding
+
+	// keep the openAI section working for config without embedding section.
+	if c.Provider == "" && ctx.Config.OpenAI.Token != "" {
+		c.Provider = ProviderOpenAI
+	}
+
+	if c.Provider == "" {
+		return nil, ErrNotConfigured
+	}
+
+	if c.Provider == ProviderOpenAI {
+		if c.Endpoint == "" {
+			c.Endpoint = ctx.Config.OpenAI.Endpoint
+		}
+		if c.Token == "" {
+			c.Token = ctx.Config.OpenAI.Token
+		}
+		if c.Model == "" {
+			c.Model = "AdaEmbeddingV2"
+		}
+	}
+
+	factory, found := providers[c.Provider]
+	if !found {
+		return nil, fmt.Errorf("embedding provider-[%s] not found", c.Provider)
+	}
+
+	em, err := factory(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Cache.Enabled && ctx.Redis != nil {
+		alias := fmt.Sprintf("%s_%s_%d", c.Provider, c.Model, c.Dimensions)
+		em = NewCache(em, ctx.Redis, alias, time.Duration(c.Cache.TTLSec)*time.Second)
+	}
+
+	return em, nil
 }
 
 type DocumentRequest struct {
@@ -56,6 +123,21 @@ func (v Vector) DotProduct(other Vector) (float32, error) {
 	return dotProduct, nil
 }
 
+// L2Distance returns the squared euclidean distance, the same value milvus reports for the L2 metric.
+func (v Vector) L2Distance(other Vector) (float32, error) {
+	if len(v) != len(other) {
+		return 0, ErrVectorLengthMismatch
+	}
+
+	var distance float32
+	for i := range v {
+		d := v[i] - other[i]
+		distance += d * d
+	}
+
+	return distance, nil
+}
+
 type Usage struct {
 	PromptTokens     int
 	CompletionTokens int
@@ -0,0 +1,112 @@
+package embedding
+
+import (
+	"context"
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
+	"github.com/ringbrew/newaim/productsearch/internal/domain"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+const defaultHashingDimensions = 256
+
+/*
+@desc: Hashing is a deterministic feature hashing embedder, it needs no model or network,
+words and word bigrams are hashed into a signed bucket and the vector is l2 normalized.
+*/
+type Hashing struct {
+	dimensions int
+}
+
+func newHashingProvider(ctx *domain.UseCaseContext, c conf.Embedding) (Embedding, error) {
+	return newHashing(c.Dimensions), nil
+}
+
+func newHashing(dimensions int) *Hashing {
+	if dimensions <= 0 {
+		dimensions = defaultHashingDimensions
+	}
+
+	return &Hashing{
+		dimensions: dimensions,
+	}
+}
+
+func (h *Hashing) EmbedDocument(ctx context.Context, req DocumentRequest) (DocumentResponse, error) {
+	result := DocumentResponse{
+		Data: make([]Data, 0, len(req.Documents)),
+	}
+
+	for i, v := range req.Documents {
+		vector, tokens := h.vector(v)
+		result.Data = append(result.Data, Data{
+			Content: v,
+			Vector:  vector,
+			Index:   i,
+		})
+		result.Usage.PromptTokens += tokens
+		result.Usage.TotalTokens += tokens
+	}
+
+	return result, nil
+}
+
+func (h *Hashing) EmbedSingle(ctx context.Context, req SingleRequest) (SingleResponse, error) {
+	vector, tokens := h.vector(req.Content)
+
+	return SingleResponse{
+		Data: Data{
+			Content: req.Content,
+			Vector:  vector,
+		},
+		Usage: Usage{
+			PromptTokens: tokens,
+			TotalTokens:  tokens,
+		},
+	}, nil
+}
+
+func (h *Hashing) vector(content string) (Vector, int) {
+	result := make(Vector, h.dimensions)
+
+	words := strings.FieldsFunc(strings.ToLower(content), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	features := make([]string, 0, 2*len(words))
+	for i, v := range words {
+		features = append(features, v)
+		if i > 0 {
+			features = append(features, words[i-1]+" "+v)
+		}
+	}
+
+	for _, v := range features {
+		hash := fnv.New64a()
+		hash.Write([]byte(v))
+		sum := hash.Sum64()
+
+		// the extra bit decides the sign, so collisions cancel out instead of piling up.
+		if sum&(1<<63) == 0 {
+			result[sum%uint64(h.dimensions)] += 1
+		} else {
+			result[sum%uint64(h.dimensions)] -= 1
+		}
+	}
+
+	var norm float64
+	for _, v := range result {
+		norm += float64(v * v)
+	}
+
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range result {
+			result[i] = float32(float64(result[i]) / norm)
+		}
+	}
+
+	return result, len(words)
+}
@@ -0,0 +1,39 @@
+package embedding
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHashing(t *testing.T) {
+	h := newHashing(64)
+
+	a, err := h.EmbedSingle(context.Background(), SingleRequest{Content: "Nike Air Max running shoes"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	b, err := h.EmbedSingle(context.Background(), SingleRequest{Content: "nike air max, running shoes"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	c, err := h.EmbedSingle(context.Background(), SingleRequest{Content: "stainless steel kitchen knife"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(a.Data.Vector) != 64 {
+		t.Fatalf("unexpected dimensions: %d", len(a.Data.Vector))
+	}
+
+	same, _ := a.Data.Vector.DotProduct(b.Data.Vector)
+	if same < 0.999 {
+		t.Errorf("same content should embed the same, similarity: %f", same)
+	}
+
+	other, _ := a.Data.Vector.DotProduct(c.Data.Vector)
+	if other >= same {
+		t.Errorf("unrelated content should be less similar, similarity: %f", other)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
 	"github.com/ringbrew/newaim/productsearch/internal/domain"
 	"github.com/sashabaranov/go-openai"
 )
@@ -12,30 +13,60 @@ var modelAliasMap = map[string]openai.EmbeddingModel{
 	"AdaEmbeddingV2": openai.AdaEmbeddingV2,
 }
 
+/*
+@desc: newer openai models, not known by the sdk enum, are served by the compatible client.
+*/
+var compatibleModelAliasMap = map[string]string{
+	"SmallEmbedding3": "text-embedding-3-small",
+	"LargeEmbedding3": "text-embedding-3-large",
+}
+
+const openAIEndpoint = "https://api.openai.com/v1"
+
 type OpenAI struct {
-	ctx            *domain.UseCaseContext
+	endpoint       string
+	token          string
 	embeddingModel openai.EmbeddingModel
 }
 
-func newOpenAI(ctx *domain.UseCaseContext, embeddingModelAlias string) (*OpenAI, error) {
-	if ctx.Config.OpenAI.Endpoint == "" || ctx.Config.OpenAI.Token == "" {
+func newOpenAIProvider(ctx *domain.UseCaseContext, c conf.Embedding) (Embedding, error) {
+	if model, found := compatibleModelAliasMap[c.Model]; found {
+		c.Model = model
+	}
+
+	if _, found := modelAliasMap[c.Model]; !found {
+		if c.Endpoint == "" {
+			c.Endpoint = openAIEndpoint
+		}
+		if c.Token == "" {
+			return nil, errors.New("invalid open ai config")
+		}
+		return newCompatible(c)
+	}
+
+	return newOpenAI(c)
+}
+
+func newOpenAI(c conf.Embedding) (*OpenAI, error) {
+	if c.Endpoint == "" || c.Token == "" {
 		return nil, errors.New("invalid open ai config")
 	}
 
-	em, found := modelAliasMap[embeddingModelAlias]
+	em, found := modelAliasMap[c.Model]
 	if !found {
-		return nil, fmt.Errorf("model-[%s] mapping not found", embeddingModelAlias)
+		return nil, fmt.Errorf("model-[%s] mapping not found", c.Model)
 	}
 
 	return &OpenAI{
-		ctx:            ctx,
+		endpoint:       c.Endpoint,
+		token:          c.Token,
 		embeddingModel: em,
 	}, nil
 }
 
 func (oa *OpenAI) EmbedDocument(ctx context.Context, req DocumentRequest) (DocumentResponse, error) {
-	config := openai.DefaultConfig(oa.ctx.Config.OpenAI.Token)
-	config.BaseURL = oa.ctx.Config.OpenAI.Endpoint
+	config := openai.DefaultConfig(oa.token)
+	config.BaseURL = oa.endpoint
 	client := openai.NewClientWithConfig(config)
 
 	// Create an EmbeddingRequest for the user query
@@ -71,8 +102,8 @@ func (oa *OpenAI) EmbedDocument(ctx context.Context, req DocumentRequest) (Docum
 }
 
 func (oa *OpenAI) EmbedSingle(ctx context.Context, req SingleRequest) (SingleResponse, error) {
-	config := openai.DefaultConfig(oa.ctx.Config.OpenAI.Token)
-	config.BaseURL = oa.ctx.Config.OpenAI.Endpoint
+	config := openai.DefaultConfig(oa.token)
+	config.BaseURL = oa.endpoint
 	client := openai.NewClientWithConfig(config)
 
 	// Create an EmbeddingRequest for the user query
@@ -0,0 +1,125 @@
+package product
+
+import (
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
+)
+
+const (
+	defaultRecencyFunction = "gauss"
+	defaultRecencyScale    = "30d"
+	defaultRecencyDecay    = 0.5
+	// log2p keeps a product without popularity above zero, a multiplied zero would drop its relevance.
+	defaultPopularityMod = "log2p"
+	defaultBoostMode     = "multiply"
+	defaultScoreMode     = "sum"
+)
+
+type BoostOption struct {
+	Recency    *conf.RecencyBoost
+	Popularity *conf.PopularityBoost
+	BoostMode  string
+	ScoreMode  string
+	MaxBoost   float64
+}
+
+/*
+@desc: boost option from config, nil when no boost is enabled.
+*/
+func newBoostOption(c conf.SearchBoost) *BoostOption {
+	result := &BoostOption{
+		BoostMode: c.BoostMode,
+		ScoreMode: c.ScoreMode,
+		MaxBoost:  c.MaxBoost,
+	}
+
+	if c.Recency.Weight > 0 {
+		r := c.Recency
+		switch r.Function {
+		case "gauss", "exp", "linear":
+		default:
+			r.Function = defaultRecencyFunction
+		}
+		if r.Scale == "" {
+			r.Scale = defaultRecencyScale
+		}
+		if r.Decay <= 0 || r.Decay >= 1 {
+			r.Decay = defaultRecencyDecay
+		}
+		result.Recency = &r
+	}
+
+	if c.Popularity.Weight > 0 {
+		p := c.Popularity
+		if p.Modifier == "" {
+			p.Modifier = defaultPopularityMod
+		}
+		if p.Factor <= 0 {
+			p.Factor = 1
+		}
+		result.Popularity = &p
+	}
+
+	if result.Recency == nil && result.Popularity == nil {
+		return nil
+	}
+
+	if result.BoostMode == "" {
+		result.BoostMode = defaultBoostMode
+	}
+	if result.ScoreMode == "" {
+		result.ScoreMode = defaultScoreMode
+	}
+
+	return result
+}
+
+/*
+@desc: wrap the query in a function_score, the text relevance is combined with the boost by the boost mode.
+*/
+func (b BoostOption) wrap(query map[string]interface{}) map[string]interface{} {
+	functions := make([]map[string]interface{}, 0, 2)
+
+	if r := b.Recency; r != nil {
+		decay := map[string]interface{}{
+			"origin": "now",
+			"scale":  r.Scale,
+			"decay":  r.Decay,
+		}
+		if r.Offset != "" {
+			decay["offset"] = r.Offset
+		}
+
+		functions = append(functions, map[string]interface{}{
+			r.Function: map[string]interface{}{
+				"updateTime": decay,
+			},
+			"weight": r.Weight,
+		})
+	}
+
+	if p := b.Popularity; p != nil {
+		functions = append(functions, map[string]interface{}{
+			"field_value_factor": map[string]interface{}{
+				"field":    "popularity",
+				"factor":   p.Factor,
+				"modifier": p.Modifier,
+				"missing":  p.Missing,
+			},
+			"weight": p.Weight,
+		})
+	}
+
+	functionScore := map[string]interface{}{
+		"query":      query,
+		"functions":  functions,
+		"boost_mode": b.BoostMode,
+		"score_mode": b.ScoreMode,
+	}
+	if b.MaxBoost > 0 {
+		functionScore["max_boost"] = b.MaxBoost
+	}
+
+	return map[string]interface{}{
+		"function_score": functionScore,
+	}
+}
@@ -0,0 +1,30 @@
+package product
+
+import (
+	"encoding/json"
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
+	"testing"
+)
+
+func TestBoostOption(t *testing.T) {
+	if b := newBoostOption(conf.SearchBoost{}); b != nil {
+		t.Fatalf("boost without weight should be disabled: %+v", b)
+	}
+
+	b := newBoostOption(conf.SearchBoost{
+		Recency: conf.RecencyBoost{Weight: 2, Function: "unknown"},
+	})
+	if b == nil || b.Recency == nil || b.Popularity != nil {
+		t.Fatalf("unexpected boost: %+v", b)
+	}
+
+	data, err := json.Marshal(b.wrap(map[string]interface{}{"match_all": map[string]interface{}{}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"function_score":{"boost_mode":"multiply","functions":[{"gauss":{"updateTime":{"decay":0.5,"origin":"now","scale":"30d"}},"weight":2}],"query":{"match_all":{}},"score_mode":"sum"}}`
+	if string(data) != want {
+		t.Errorf("unexpected function_score: %s", data)
+	}
+}
@@ -0,0 +1,157 @@
+package product
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"log"
+	"net/http"
+)
+
+const (
+	// CursorStart opens a new cursor, the next page is asked with the returned cursor.
+	CursorStart = "*"
+
+	cursorKeepAlive = "1m"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+/*
+@desc: position of a cursor pagination, the point in time keeps the result stable between pages.
+*/
+type Cursor struct {
+	PitId       string            `json:"p"`
+	SearchAfter []json.RawMessage `json:"a"`
+}
+
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (Cursor, error) {
+	var result Cursor
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return result, ErrInvalidCursor
+	}
+
+	if err := json.Unmarshal(data, &result); err != nil || result.PitId == "" {
+		return result, ErrInvalidCursor
+	}
+
+	return result, nil
+}
+
+func (r *repo) OpenPit(ctx context.Context) (string, error) {
+	req := esapi.OpenPointInTimeRequest{
+		Index:     []string{productIndex},
+		KeepAlive: cursorKeepAlive,
+	}
+
+	resp, err := req.Do(ctx, r.es)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		return "", fmt.Errorf("open point in time fail: %s", resp.String())
+	}
+
+	var result struct {
+		Id string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	return result.Id, nil
+}
+
+func (r *repo) ClosePit(ctx context.Context, id string) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"id": id,
+	})
+	if err != nil {
+		return err
+	}
+
+	req := esapi.ClosePointInTimeRequest{
+		Body: bytes.NewReader(data),
+	}
+
+	resp, err := req.Do(ctx, r.es)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		return fmt.Errorf("close point in time fail: %s", resp.String())
+	}
+
+	return nil
+}
+
+/*
+@desc: whether es rejected the point in time or search_after of the cursor: expired or unknown (404),
+malformed or forged (400), they all come from the client.
+*/
+func cursorRejected(status int) bool {
+	return status == http.StatusBadRequest || status == http.StatusNotFound
+}
+
+/*
+@desc: lexical page after the cursor, there is no vector fallback nor spelling pass in cursor mode.
+the point in time is closed with the last page, an empty next cursor means the end.
+*/
+func (uc *UseCase) cursorQuery(ctx context.Context, req QueryRequest) (QueryResponse, error) {
+	var (
+		c   Cursor
+		err error
+	)
+
+	if req.Cursor == CursorStart {
+		c.PitId, err = uc.repo.OpenPit(ctx)
+	} else {
+		c, err = decodeCursor(req.Cursor)
+	}
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	req.cursor = &c
+	lexical, err := uc.lexicalQuery(ctx, req, 0, req.Size)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	resp := QueryResponse{
+		Data:   lexical.Data,
+		Total:  lexical.Total,
+		Facets: lexical.Facets,
+	}
+
+	if int64(len(lexical.Data)) == req.Size && len(lexical.lastSort) > 0 {
+		// es may hand out a new id for the same point in time.
+		next := Cursor{
+			PitId:       lexical.pitId,
+			SearchAfter: lexical.lastSort,
+		}
+		if next.PitId == "" {
+			next.PitId = c.PitId
+		}
+		resp.NextCursor = next.Encode()
+	} else if err := uc.repo.ClosePit(ctx, c.PitId); err != nil {
+		log.Printf("close point in time fail: %s", err.Error())
+	}
+
+	return resp, nil
+}
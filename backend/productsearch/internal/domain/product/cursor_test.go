@@ -0,0 +1,45 @@
+package product
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	c := Cursor{
+		PitId:       "46ToAwMDaWR5BXV1aWQy",
+		SearchAfter: []json.RawMessage{json.RawMessage(`1.25`), json.RawMessage(`9007199254740993`)},
+	}
+
+	decoded, err := decodeCursor(c.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a long sort value must survive without float rounding.
+	if decoded.PitId != c.PitId || len(decoded.SearchAfter) != 2 || string(decoded.SearchAfter[1]) != "9007199254740993" {
+		t.Errorf("unexpected decoded cursor: %+v", decoded)
+	}
+
+	for _, v := range []string{"not base64!", Cursor{}.Encode()} {
+		if _, err := decodeCursor(v); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("decodeCursor(%q) should fail, got %v", v, err)
+		}
+	}
+}
+
+func TestCursorRejected(t *testing.T) {
+	cases := map[int]bool{
+		http.StatusBadRequest:          true,
+		http.StatusNotFound:            true,
+		http.StatusInternalServerError: false,
+		http.StatusServiceUnavailable:  false,
+	}
+	for status, rejected := range cases {
+		if cursorRejected(status) != rejected {
+			t.Errorf("cursorRejected(%d) should be %v", status, rejected)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package product
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
+	"log"
+)
+
+/*
+@desc: ElasticStore keeps the vector as a dense_vector field of the product document,
+so the query result comes back with the product itself.
+*/
+type ElasticStore struct {
+	repo   *repo
+	index  string
+	metric string
+	exact  bool
+}
+
+func newElasticStore(r *repo, c conf.VectorStore) (*ElasticStore, error) {
+	if c.Dims <= 0 {
+		return nil, errors.New("invalid elasticsearch vector store dims")
+	}
+
+	metric := c.Metric
+	if metric == "" {
+		metric = MetricL2
+	}
+
+	if metric != MetricL2 && metric != MetricIP {
+		return nil, errors.New("invalid elasticsearch vector store metric")
+	}
+
+	return &ElasticStore{
+		repo:   r,
+		index:  productIndex,
+		metric: metric,
+		exact:  c.Exact,
+	}, nil
+}
+
+func (es *ElasticStore) BatchCreate(ctx context.Context, ds []*Product) error {
+	if len(ds) == 0 {
+		return errors.New("empty ds")
+	}
+
+	for _, v := range ds {
+		data, err := json.Marshal(map[string]interface{}{
+			"doc": map[string]interface{}{
+				VectorField: v.Vector,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := es.repo.bulkIndex[es.index].Add(
+			ctx,
+			esutil.BulkIndexerItem{
+				Action:     "update",
+				DocumentID: v.Id,
+				Body:       bytes.NewReader(data),
+				OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+					if err != nil {
+						log.Printf("ERROR: %s", err)
+					} else {
+						log.Printf("ERROR: %s: %s", res.Error.Type, res.Error.Reason)
+					}
+				},
+			},
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// the index of the store, a rebuild writes the new version and not the live alias.
+func (es *ElasticStore) Upsert(ctx context.Context, p *Product) error {
+	return es.repo.updateDoc(ctx, es.index, p.Id, map[string]interface{}{
+		"doc": map[string]interface{}{
+			VectorField: p.Vector,
+		},
+	})
+}
+
+func (es *ElasticStore) withIndex(index string) VectorStore {
+	return &ElasticStore{
+		repo:   es.repo,
+		index:  index,
+		metric: es.metric,
+		exact:  es.exact,
+	}
+}
+
+func (es *ElasticStore) Delete(ctx context.Context, p Product) error {
+	err := es.repo.updateDoc(ctx, es.index, p.Id, map[string]interface{}{
+		"script": map[string]interface{}{
+			"source": "ctx._source.remove(params.field)",
+			"params": map[string]interface{}{
+				"field": VectorField,
+			},
+		},
+	})
+
+	// the vector is gone together with the document.
+	if errors.Is(err, ErrProductNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+func (es *ElasticStore) Query(ctx context.Context, request QueryVectorRequest) (QueryVectorResponse, error) {
+	query := map[string]interface{}{
+		"fields": productFields,
+	}
+
+	if es.exact {
+		source := "1 / (1 + l2norm(params.query_vector, 'vector'))"
+		if es.metric == MetricIP {
+			source = "double value = dotProduct(params.query_vector, 'vector'); return sigmoid(1, Math.E, -value);"
+		}
+
+		query["query"] = map[string]interface{}{
+			"script_score": map[string]interface{}{
+				"query": map[string]interface{}{
+					"bool": map[string]interface{}{
+						"must": map[string]interface{}{
+							"exists": map[string]interface{}{
+								"field": VectorField,
+							},
+						},
+						"filter": request.Filter.clauses(),
+					},
+				},
+				"script": map[string]interface{}{
+					"source": source,
+					"params": map[string]interface{}{
+						"query_vector": request.Input,
+					},
+				},
+			},
+		}
+	} else {
+		numCandidates := request.Top * 10
+		if numCandidates < 100 {
+			numCandidates = 100
+		}
+
+		query["knn"] = map[string]interface{}{
+			"field":          VectorField,
+			"query_vector":   request.Input,
+			"k":              request.Top,
+			"num_candidates": numCandidates,
+			"filter":         request.Filter.clauses(),
+		}
+	}
+
+	result, err := es.repo.searchProductByQuery(ctx, 0, int64(request.Top), query)
+	if err != nil {
+		return QueryVectorResponse{}, err
+	}
+
+	data := make([]QueryVectorRes, 0, len(result.Data))
+	for i := range result.Data {
+		data = append(data, QueryVectorRes{
+			Id:      result.Data[i].Id,
+			Score:   float32(result.Data[i].Score),
+			Product: &result.Data[i],
+		})
+	}
+
+	return QueryVectorResponse{
+		Data: data,
+	}, nil
+}
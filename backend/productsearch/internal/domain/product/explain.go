@@ -0,0 +1,139 @@
+package product
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+const (
+	BranchSku    = "sku"
+	BranchText   = "text"
+	BranchVector = "vector"
+	BranchHybrid = "hybrid"
+)
+
+/*
+@desc: debug record of one query, es only computes the explanation when it is asked for.
+*/
+type Explain struct {
+	// Searches every es request the query sent, in order.
+	Searches []ExplainSearch `json:"searches"`
+	// Vector hits of the vector store, the score is the distance or similarity of the store metric.
+	Vector []ExplainVector `json:"vector,omitempty"`
+	// Branch product id to the branch which produced it.
+	Branch map[string]string `json:"branch"`
+
+	mu sync.Mutex
+}
+
+type ExplainSearch struct {
+	Index string          `json:"index"`
+	Query json.RawMessage `json:"query"`
+	Hits  []ExplainHit    `json:"hits"`
+}
+
+type ExplainHit struct {
+	Id          string          `json:"id"`
+	Score       float64         `json:"score"`
+	Explanation json.RawMessage `json:"explanation,omitempty"`
+}
+
+type ExplainVector struct {
+	Id    string  `json:"id"`
+	Score float32 `json:"score"`
+}
+
+type explainKey struct{}
+
+func withExplain(ctx context.Context) (context.Context, *Explain) {
+	e := &Explain{
+		Searches: make([]ExplainSearch, 0),
+		Branch:   make(map[string]string),
+	}
+	return context.WithValue(ctx, explainKey{}, e), e
+}
+
+/*
+@desc: the recorder of the context, nil when the query is not explained. all methods accept a nil receiver.
+*/
+func explainFrom(ctx context.Context) *Explain {
+	e, _ := ctx.Value(explainKey{}).(*Explain)
+	return e
+}
+
+func (e *Explain) search(index string, query map[string]interface{}, data ESResponse) {
+	if e == nil {
+		return
+	}
+
+	q, err := json.Marshal(query)
+	if err != nil {
+		return
+	}
+
+	s := ExplainSearch{
+		Index: index,
+		Query: q,
+		Hits:  make([]ExplainHit, 0, len(data.Hits.Hits)),
+	}
+	for _, v := range data.Hits.Hits {
+		s.Hits = append(s.Hits, ExplainHit{
+			Id:          v.Id,
+			Score:       v.Score,
+			Explanation: v.Explanation,
+		})
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Searches = append(e.Searches, s)
+}
+
+func (e *Explain) vector(vr QueryVectorResponse) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, v := range vr.Data {
+		e.Vector = append(e.Vector, ExplainVector{
+			Id:    v.Id,
+			Score: v.Score,
+		})
+	}
+}
+
+func (e *Explain) branch(products []Product, branch string) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, v := range products {
+		e.Branch[v.Id] = branch
+	}
+}
+
+/*
+@desc: run the query with explanation of every es request and the branch of every result.
+*/
+func (uc *UseCase) Explain(ctx context.Context, req QueryRequest) (QueryResponse, *Explain, error) {
+	ctx, e := withExplain(ctx)
+
+	resp, err := uc.Query(ctx, req)
+	if err != nil {
+		return QueryResponse{}, nil, err
+	}
+
+	// branch of the results outside of the page are of no interest.
+	inPage := make(map[string]string, len(resp.Data))
+	for _, v := range resp.Data {
+		inPage[v.Id] = e.Branch[v.Id]
+	}
+	e.Branch = inPage
+
+	return resp, e, nil
+}
@@ -0,0 +1,178 @@
+package product
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	FacetCategory   = "category"
+	FacetBrand      = "brand"
+	FacetCreateTime = "createTime"
+	FacetUpdateTime = "updateTime"
+
+	defaultFacetSize = 10
+)
+
+/*
+@desc: structured filter on product fields, the zero value filters nothing.
+*/
+type Filter struct {
+	CreateTimeFrom *time.Time
+	CreateTimeTo   *time.Time
+	UpdateTimeFrom *time.Time
+	UpdateTimeTo   *time.Time
+	SkuPrefix      string
+	Category       []string
+	Brand          []string
+	// ExcludeSku the pinned sku of the rules, shown apart from the search.
+	ExcludeSku []string
+}
+
+type FacetBucket struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+func ValidFacet(facet string) bool {
+	switch facet {
+	case FacetCategory, FacetBrand, FacetCreateTime, FacetUpdateTime:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f Filter) clauses() []map[string]interface{} {
+	result := make([]map[string]interface{}, 0)
+
+	dateRange := func(field string, from, to *time.Time) {
+		if from == nil && to == nil {
+			return
+		}
+
+		r := map[string]interface{}{}
+		if from != nil {
+			r["gte"] = from.Format(time.RFC3339)
+		}
+		if to != nil {
+			r["lte"] = to.Format(time.RFC3339)
+		}
+
+		result = append(result, map[string]interface{}{
+			"range": map[string]interface{}{
+				field: r,
+			},
+		})
+	}
+
+	dateRange("createTime", f.CreateTimeFrom, f.CreateTimeTo)
+	dateRange("updateTime", f.UpdateTimeFrom, f.UpdateTimeTo)
+
+	if prefix := NormalizeSku(f.SkuPrefix); prefix != "" {
+		result = append(result, map[string]interface{}{
+			"prefix": map[string]interface{}{
+				"sku.normalized": prefix,
+			},
+		})
+	}
+
+	if len(f.Category) > 0 {
+		result = append(result, map[string]interface{}{
+			"terms": map[string]interface{}{
+				"category": f.Category,
+			},
+		})
+	}
+
+	if len(f.Brand) > 0 {
+		result = append(result, map[string]interface{}{
+			"terms": map[string]interface{}{
+				"brand": f.Brand,
+			},
+		})
+	}
+
+	if len(f.ExcludeSku) > 0 {
+		result = append(result, map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must_not": map[string]interface{}{
+					"terms": map[string]interface{}{
+						"sku": f.ExcludeSku,
+					},
+				},
+			},
+		})
+	}
+
+	return result
+}
+
+func facetAggs(facets []string, size int) map[string]interface{} {
+	if size <= 0 {
+		size = defaultFacetSize
+	}
+
+	result := make(map[string]interface{})
+	for _, v := range facets {
+		switch v {
+		case FacetCategory, FacetBrand:
+			result[v] = map[string]interface{}{
+				"terms": map[string]interface{}{
+					"field": v,
+					"size":  size,
+				},
+			}
+		case FacetCreateTime, FacetUpdateTime:
+			result[v] = map[string]interface{}{
+				"date_histogram": map[string]interface{}{
+					"field":             v,
+					"calendar_interval": "month",
+					"format":            "yyyy-MM",
+					"min_doc_count":     1,
+					"order": map[string]interface{}{
+						"_key": "desc",
+					},
+				},
+			}
+		}
+	}
+
+	return result
+}
+
+func parseFacets(aggregations map[string]interface{}) map[string][]FacetBucket {
+	if len(aggregations) == 0 {
+		return nil
+	}
+
+	result := make(map[string][]FacetBucket, len(aggregations))
+	for name, agg := range aggregations {
+		aggMap, ok := agg.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		buckets, _ := aggMap["buckets"].([]interface{})
+		result[name] = make([]FacetBucket, 0, len(buckets))
+		for _, b := range buckets {
+			bucket, ok := b.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			key, found := bucket["key_as_string"]
+			if !found {
+				key = bucket["key"]
+			}
+
+			count, _ := bucket["doc_count"].(float64)
+			result[name] = append(result[name], FacetBucket{
+				Key:   fmt.Sprint(key),
+				Count: int64(count),
+			})
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,54 @@
+package product
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFilterClauses(t *testing.T) {
+	if clauses := (Filter{}).clauses(); clauses == nil || len(clauses) != 0 {
+		t.Fatalf("empty filter should give an empty clause list: %v", clauses)
+	}
+
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := Filter{
+		CreateTimeFrom: &from,
+		SkuPrefix:      "AB",
+		Category:       []string{"bike"},
+	}
+
+	clauses := f.clauses()
+	if len(clauses) != 3 {
+		t.Fatalf("unexpected clause size: %d", len(clauses))
+	}
+
+	r := clauses[0]["range"].(map[string]interface{})["createTime"].(map[string]interface{})
+	if r["gte"] != "2023-01-01T00:00:00Z" {
+		t.Errorf("unexpected range: %v", r)
+	}
+	if _, found := r["lte"]; found {
+		t.Errorf("open range should not have an upper bound: %v", r)
+	}
+}
+
+func TestParseFacets(t *testing.T) {
+	facets := parseFacets(map[string]interface{}{
+		FacetBrand: map[string]interface{}{
+			"buckets": []interface{}{
+				map[string]interface{}{"key": "newaim", "doc_count": float64(3)},
+			},
+		},
+		FacetCreateTime: map[string]interface{}{
+			"buckets": []interface{}{
+				map[string]interface{}{"key": float64(1672531200000), "key_as_string": "2023-01", "doc_count": float64(2)},
+			},
+		},
+	})
+
+	if b := facets[FacetBrand]; len(b) != 1 || b[0].Key != "newaim" || b[0].Count != 3 {
+		t.Errorf("unexpected brand facet: %v", b)
+	}
+	if b := facets[FacetCreateTime]; len(b) != 1 || b[0].Key != "2023-01" || b[0].Count != 2 {
+		t.Errorf("unexpected createTime facet: %v", b)
+	}
+}
@@ -0,0 +1,60 @@
+package product
+
+import (
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
+)
+
+const (
+	defaultFragmentSize = 100
+	defaultFragments    = 3
+	defaultPreTag       = "<em>"
+	defaultPostTag      = "</em>"
+)
+
+var highlightFields = []string{"title", "description"}
+
+type HighlightOption struct {
+	FragmentSize int
+	Fragments    int
+	PreTag       string
+	PostTag      string
+}
+
+/*
+@desc: highlight option from config, unset value use the es default.
+*/
+func newHighlightOption(c conf.SearchHighlight) *HighlightOption {
+	result := &HighlightOption{
+		FragmentSize: c.FragmentSize,
+		Fragments:    c.Fragments,
+		PreTag:       c.PreTag,
+		PostTag:      c.PostTag,
+	}
+
+	if result.FragmentSize <= 0 {
+		result.FragmentSize = defaultFragmentSize
+	}
+	if result.Fragments <= 0 {
+		result.Fragments = defaultFragments
+	}
+	if result.PreTag == "" || result.PostTag == "" {
+		result.PreTag, result.PostTag = defaultPreTag, defaultPostTag
+	}
+
+	return result
+}
+
+func (h HighlightOption) query() map[string]interface{} {
+	fields := make(map[string]interface{}, len(highlightFields))
+	for _, v := range highlightFields {
+		fields[v] = map[string]interface{}{}
+	}
+
+	return map[string]interface{}{
+		"pre_tags":            []string{h.PreTag},
+		"post_tags":           []string{h.PostTag},
+		"fragment_size":       h.FragmentSize,
+		"number_of_fragments": h.Fragments,
+		"fields":              fields,
+	}
+}
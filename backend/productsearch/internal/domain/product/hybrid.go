@@ -0,0 +1,226 @@
+package product
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	FusionRRF      = "rrf"
+	FusionWeighted = "weighted"
+
+	defaultRRFK = 60
+)
+
+type HybridOption struct {
+	// Fusion rrf(reciprocal rank fusion) or weighted(min-max normalized score).
+	Fusion        string
+	LexicalWeight *float64
+	VectorWeight  *float64
+	// RRFK rank constant of rrf.
+	RRFK int
+}
+
+/*
+@desc: contribution of each retriever to the fused Product.Score.
+*/
+type ScoreDetail struct {
+	Lexical     float64 `json:"lexical"`
+	LexicalRank int     `json:"lexicalRank,omitempty"`
+	Vector      float64 `json:"vector"`
+	VectorRank  int     `json:"vectorRank,omitempty"`
+}
+
+type fusedHit struct {
+	Id     string
+	Score  float64
+	Detail ScoreDetail
+}
+
+func (uc *UseCase) hybridQuery(ctx context.Context, req QueryRequest) (QueryResponse, error) {
+	// both lists are fetched from the top, the page must start within the vector max window.
+	window := uc.vectorWindow(req)
+	if req.From >= window {
+		return QueryResponse{}, fmt.Errorf("%w: from-[%d] beyond window-[%d]", ErrPageTooDeep, req.From, window)
+	}
+
+	var (
+		wg         sync.WaitGroup
+		lexical    SearchResult
+		lexicalErr error
+		vr         QueryVectorResponse
+		vectorErr  error
+	)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		lexical, lexicalErr = uc.lexicalQuery(ctx, req, 0, window)
+	}()
+	go func() {
+		defer wg.Done()
+		vr, vectorErr = uc.vectorQuery(ctx, req, int(window))
+	}()
+	wg.Wait()
+
+	if lexicalErr != nil {
+		return QueryResponse{}, lexicalErr
+	}
+	if vectorErr != nil {
+		return QueryResponse{}, vectorErr
+	}
+
+	fused := fuse(lexical.Data, vr.Data, req.Hybrid)
+
+	products := make(map[string]Product, len(fused))
+	for _, v := range lexical.Data {
+		products[v.Id] = v
+	}
+
+	missing := QueryVectorResponse{}
+	for _, v := range vr.Data {
+		if _, found := products[v.Id]; found {
+			continue
+		}
+		if v.Product != nil {
+			products[v.Id] = *v.Product
+		} else {
+			missing.Data = append(missing.Data, v)
+		}
+	}
+
+	if len(missing.Data) > 0 {
+		hydrated, err := uc.hydrate(ctx, missing, req.Filter)
+		if err != nil {
+			return QueryResponse{}, err
+		}
+		for _, v := range hydrated {
+			products[v.Id] = v
+		}
+	}
+
+	// vector hits which are gone from es or rejected by the filter are dropped before paging.
+	kept := fused[:0]
+	for _, v := range fused {
+		if _, found := products[v.Id]; found {
+			kept = append(kept, v)
+		}
+	}
+	fused = kept
+
+	// products only known by the vector store enlarge the lexical total.
+	total := lexical.Total
+	for _, v := range fused {
+		if v.Detail.LexicalRank == 0 {
+			total++
+		}
+	}
+
+	ordered := make([]Product, 0, len(fused))
+	for _, v := range fused {
+		p := products[v.Id]
+
+		detail := v.Detail
+		p.Score = v.Score
+		p.ScoreDetail = &detail
+		ordered = append(ordered, p)
+	}
+
+	// an explicit sort reorders the fused candidates, relevance keeps the fusion order.
+	sortProducts(ordered, req.Sort)
+
+	result := make([]Product, 0, req.Size)
+	for i := req.From; i < int64(len(ordered)) && i < window; i++ {
+		p := ordered[i]
+		result = append(result, p)
+
+		// a lexical only hit keeps the sku or text branch of the lexical query.
+		if p.ScoreDetail.LexicalRank == 0 {
+			explainFrom(ctx).branch([]Product{p}, BranchVector)
+		} else if p.ScoreDetail.VectorRank > 0 {
+			explainFrom(ctx).branch([]Product{p}, BranchHybrid)
+		}
+	}
+
+	return QueryResponse{
+		Data:   result,
+		Total:  total,
+		Facets: lexical.Facets,
+		// vector only products are counted up to the window.
+		Estimated: int64(len(vr.Data)) >= window,
+	}, nil
+}
+
+/*
+@desc: merge the lexical and vector ranking, both input list are ordered best first.
+*/
+func fuse(lexical []Product, vector []QueryVectorRes, opt HybridOption) []fusedHit {
+	lexicalWeight, vectorWeight := 1.0, 1.0
+	if opt.LexicalWeight != nil {
+		lexicalWeight = *opt.LexicalWeight
+	}
+	if opt.VectorWeight != nil {
+		vectorWeight = *opt.VectorWeight
+	}
+
+	k := opt.RRFK
+	if k <= 0 {
+		k = defaultRRFK
+	}
+
+	// min-max normalize by the best and worst score of the list, so it does not matter
+	// whether the store reports a distance(lower is better) or a similarity.
+	normalize := func(score, best, worst float64) float64 {
+		if best == worst {
+			return 1
+		}
+		return (score - worst) / (best - worst)
+	}
+
+	hits := make(map[string]*fusedHit)
+	order := make([]string, 0, len(lexical)+len(vector))
+	get := func(id string) *fusedHit {
+		h, found := hits[id]
+		if !found {
+			h = &fusedHit{Id: id}
+			hits[id] = h
+			order = append(order, id)
+		}
+		return h
+	}
+
+	for i, v := range lexical {
+		h := get(v.Id)
+		h.Detail.LexicalRank = i + 1
+		if opt.Fusion == FusionWeighted {
+			h.Detail.Lexical = lexicalWeight * normalize(v.Score, lexical[0].Score, lexical[len(lexical)-1].Score)
+		} else {
+			h.Detail.Lexical = lexicalWeight / float64(k+i+1)
+		}
+	}
+
+	for i, v := range vector {
+		h := get(v.Id)
+		h.Detail.VectorRank = i + 1
+		if opt.Fusion == FusionWeighted {
+			h.Detail.Vector = vectorWeight * normalize(float64(v.Score), float64(vector[0].Score), float64(vector[len(vector)-1].Score))
+		} else {
+			h.Detail.Vector = vectorWeight / float64(k+i+1)
+		}
+	}
+
+	result := make([]fusedHit, 0, len(order))
+	for _, id := range order {
+		h := hits[id]
+		h.Score = h.Detail.Lexical + h.Detail.Vector
+		result = append(result, *h)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Score > result[j].Score
+	})
+
+	return result
+}
@@ -0,0 +1,63 @@
+package product
+
+import (
+	"context"
+	"errors"
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
+	"github.com/ringbrew/newaim/productsearch/internal/domain"
+	"testing"
+)
+
+func TestFuse(t *testing.T) {
+	lexical := []Product{
+		{Id: "a", Score: 9},
+		{Id: "b", Score: 5},
+		{Id: "c", Score: 1},
+	}
+
+	// l2 distance, lower is better.
+	vector := []QueryVectorRes{
+		{Id: "b", Score: 0.1},
+		{Id: "d", Score: 0.2},
+		{Id: "a", Score: 0.9},
+	}
+
+	result := fuse(lexical, vector, HybridOption{Fusion: FusionRRF})
+	if len(result) != 4 {
+		t.Fatalf("unexpected fused size: %d", len(result))
+	}
+
+	// b: 1/62 + 1/61, a: 1/61 + 1/63
+	if result[0].Id != "b" || result[1].Id != "a" {
+		t.Errorf("unexpected rrf order: %v", result)
+	}
+
+	if result[0].Detail.LexicalRank != 2 || result[0].Detail.VectorRank != 1 {
+		t.Errorf("unexpected rrf detail: %v", result[0].Detail)
+	}
+
+	vectorOnly := 0.0
+	result = fuse(lexical, vector, HybridOption{Fusion: FusionWeighted, VectorWeight: &vectorOnly})
+	if result[0].Id != "a" || result[0].Score != 1 || result[0].Detail.Vector != 0 {
+		t.Errorf("unexpected weighted result: %v", result[0])
+	}
+
+	lexicalOnly := 0.0
+	result = fuse(lexical, vector, HybridOption{Fusion: FusionWeighted, LexicalWeight: &lexicalOnly})
+	if result[0].Id != "b" || result[0].Detail.Lexical != 0 {
+		t.Errorf("unexpected weighted result: %v", result[0])
+	}
+}
+
+func TestHybridWindow(t *testing.T) {
+	uc := &UseCase{ctx: &domain.UseCaseContext{Config: conf.Config{Search: conf.Search{VectorMaxWindow: 20}}}}
+
+	if window := uc.vectorWindow(QueryRequest{From: 10, Size: 20}); window != 20 {
+		t.Errorf("window should be capped: %d", window)
+	}
+
+	// a page past the window never reaches the stores.
+	if _, err := uc.hybridQuery(context.Background(), QueryRequest{Keyword: "shoe", From: 20, Size: 10}); !errors.Is(err, ErrPageTooDeep) {
+		t.Errorf("deep page should be rejected: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package product
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultRetainVersions = 2
+
+var ErrNoRollbackVersion = errors.New("no index version to rollback")
+
+/*
+@desc: a physical product index behind the productIndex alias.
+*/
+type IndexVersion struct {
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+	Count  int64  `json:"count"`
+}
+
+func newVersionIndex() string {
+	return fmt.Sprintf("%s_v%s", productIndex, time.Now().Format("20060102150405"))
+}
+
+/*
+@desc: list the versioned index, newest first.
+*/
+func (r *repo) ListVersion(ctx context.Context) ([]IndexVersion, error) {
+	req := esapi.IndicesGetAliasRequest{
+		Index: []string{productIndex + "_v*"},
+	}
+
+	resp, err := req.Do(ctx, r.es)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.IsError() {
+		return nil, fmt.Errorf("error get alias of index-[%s], status[%s]", productIndex, resp.Status())
+	}
+
+	var data map[string]struct {
+		Aliases map[string]interface{} `json:"aliases"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	result := make([]IndexVersion, 0, len(data))
+	for name, v := range data {
+		_, active := v.Aliases[productIndex]
+		result = append(result, IndexVersion{
+			Name:   name,
+			Active: active,
+		})
+	}
+
+	// the version suffix is a timestamp, so the name order is the creation order.
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name > result[j].Name
+	})
+
+	return result, nil
+}
+
+/*
+@desc: point productIndex to index in one atomic request.
+an index created before the alias was introduced, named productIndex itself, is removed in the same request.
+*/
+func (r *repo) SwapAlias(ctx context.Context, index string) error {
+	versions, err := r.ListVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	actions := make([]map[string]interface{}, 0)
+	for _, v := range versions {
+		if v.Active && v.Name != index {
+			actions = append(actions, map[string]interface{}{
+				"remove": map[string]interface{}{
+					"index": v.Name,
+					"alias": productIndex,
+				},
+			})
+		}
+	}
+
+	legacy, err := r.isLegacyIndex(ctx)
+	if err != nil {
+		return err
+	}
+	if legacy {
+		actions = append(actions, map[string]interface{}{
+			"remove_index": map[string]interface{}{
+				"index": productIndex,
+			},
+		})
+	}
+
+	actions = append(actions, map[string]interface{}{
+		"add": map[string]interface{}{
+			"index": index,
+			"alias": productIndex,
+		},
+	})
+
+	b, err := json.Marshal(map[string]interface{}{
+		"actions": actions,
+	})
+	if err != nil {
+		return err
+	}
+
+	req := esapi.IndicesUpdateAliasesRequest{
+		Body: bytes.NewReader(b),
+	}
+
+	resp, err := req.Do(ctx, r.es)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		return fmt.Errorf("error swap alias-[%s] to index-[%s], status[%s]", productIndex, index, resp.Status())
+	}
+
+	return nil
+}
+
+func (r *repo) isLegacyIndex(ctx context.Context) (bool, error) {
+	exist, err := r.CheckIndexExist(productIndex)
+	if err != nil || !exist {
+		return false, err
+	}
+
+	req := esapi.IndicesExistsAliasRequest{
+		Name: []string{productIndex},
+	}
+
+	resp, err := req.Do(ctx, r.es)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusNotFound, nil
+}
+
+/*
+@desc: delete the inactive versions beyond the newest retain ones.
+*/
+func (r *repo) PruneVersion(ctx context.Context, retain int) error {
+	versions, err := r.ListVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i, v := range versions {
+		if i < retain || v.Active || !strings.HasPrefix(v.Name, productIndex+"_v") {
+			continue
+		}
+
+		if err := r.DeleteIndexES(v.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package product
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ringbrew/newaim/productsearch/internal/domain/embedding"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	MetricL2 = "l2"
+	MetricIP = "ip"
+)
+
+/*
+@desc: MemoryStore keeps vectors in process and queries by brute force,
+it is meant for tests and local development without milvus.
+*/
+type MemoryStore struct {
+	metric string
+	mu     sync.RWMutex
+	data   map[string]embedding.Vector
+}
+
+func newMemoryStore(metric string) (*MemoryStore, error) {
+	metric = strings.ToLower(metric)
+	if metric == "" {
+		metric = MetricL2
+	}
+
+	if metric != MetricL2 && metric != MetricIP {
+		return nil, fmt.Errorf("metric-[%s] not support", metric)
+	}
+
+	return &MemoryStore{
+		metric: metric,
+		data:   make(map[string]embedding.Vector),
+	}, nil
+}
+
+func (ms *MemoryStore) BatchCreate(ctx context.Context, ds []*Product) error {
+	if len(ds) == 0 {
+		return errors.New("empty ds")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, v := range ds {
+		ms.data[v.Id] = v.Vector
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) Upsert(ctx context.Context, p *Product) error {
+	return ms.BatchCreate(ctx, []*Product{p})
+}
+
+func (ms *MemoryStore) Delete(ctx context.Context, p Product) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.data, p.Id)
+	return nil
+}
+
+func (ms *MemoryStore) Query(ctx context.Context, request QueryVectorRequest) (QueryVectorResponse, error) {
+	ms.mu.RLock()
+	data := make([]QueryVectorRes, 0, len(ms.data))
+	for id, v := range ms.data {
+		var score float32
+		var err error
+		if ms.metric == MetricIP {
+			score, err = request.Input.DotProduct(v)
+		} else {
+			score, err = request.Input.L2Distance(v)
+		}
+		if err != nil {
+			// vectors of another dimension belong to another model, same as another milvus collection.
+			continue
+		}
+
+		data = append(data, QueryVectorRes{
+			Id:    id,
+			Score: score,
+		})
+	}
+	ms.mu.RUnlock()
+
+	sort.Slice(data, func(i, j int) bool {
+		if data[i].Score == data[j].Score {
+			return data[i].Id < data[j].Id
+		}
+		if ms.metric == MetricIP {
+			return data[i].Score > data[j].Score
+		}
+		return data[i].Score < data[j].Score
+	})
+
+	if request.Top >= 0 && len(data) > request.Top {
+		data = data[:request.Top]
+	}
+
+	return QueryVectorResponse{
+		Data: data,
+	}, nil
+}
@@ -0,0 +1,50 @@
+package product
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryStoreQuery(t *testing.T) {
+	ctx := context.Background()
+
+	data := []*Product{
+		{Id: "a", Vector: []float32{1, 0, 0}},
+		{Id: "b", Vector: []float32{0.8, 0.6, 0}},
+		{Id: "c", Vector: []float32{0, 0, 1}},
+		{Id: "d", Vector: []float32{1, 0}},
+	}
+
+	for _, metric := range []string{MetricL2, MetricIP} {
+		ms, err := newMemoryStore(metric)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if err := ms.BatchCreate(ctx, data); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		resp, err := ms.Query(ctx, QueryVectorRequest{Input: []float32{1, 0.1, 0}, Top: 2})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if len(resp.Data) != 2 || resp.Data[0].Id != "a" || resp.Data[1].Id != "b" {
+			t.Errorf("metric-[%s] unexpected result: %v", metric, resp.Data)
+		}
+
+		if err := ms.Delete(ctx, Product{Id: "a"}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		resp, err = ms.Query(ctx, QueryVectorRequest{Input: []float32{1, 0.1, 0}, Top: 10})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if len(resp.Data) != 2 || resp.Data[0].Id != "b" {
+			t.Errorf("metric-[%s] unexpected result after delete: %v", metric, resp.Data)
+		}
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"github.com/milvus-io/milvus-sdk-go/v2/client"
 	"github.com/milvus-io/milvus-sdk-go/v2/entity"
 	"github.com/ringbrew/newaim/productsearch/internal/domain"
-	"github.com/ringbrew/newaim/productsearch/internal/domain/embedding"
 	"log"
 	"strings"
 )
@@ -17,20 +16,6 @@ type MilvusStore struct {
 	client client.Client
 }
 
-type QueryVectorRequest struct {
-	Input embedding.Vector
-	Top   int
-}
-
-type QueryVectorRes struct {
-	Id    string
-	Score float32
-}
-
-type QueryVectorResponse struct {
-	Data []QueryVectorRes
-}
-
 func newMilvusStore(ctx *domain.UseCaseContext) (*MilvusStore, error) {
 	mc, err := client.NewClient(context.Background(), client.Config{
 		Address:  ctx.Config.Miluvs.Endpoint,
@@ -65,8 +50,9 @@ func newMilvusStore(ctx *domain.UseCaseContext) (*MilvusStore, error) {
 }
 
 const (
-	PARTITION   = ""
-	VectorField = "vector"
+	PARTITION        = ""
+	VectorField      = "vector"
+	collectionPrefix = "product_vector_"
 )
 
 func (ms *MilvusStore) BatchCreate(ctx context.Context, ds []*Product) error {
@@ -92,7 +78,8 @@ func (ms *MilvusStore) BatchCreate(ctx context.Context, ds []*Product) error {
 	if err != nil {
 		return err
 	}
-	if _, err := ms.client.Insert(
+	// upsert, a rebuild stores the vector of an unchanged id again.
+	if _, err := ms.client.Upsert(
 		ctx, col, PARTITION,
 		idCol,
 		vectorCol,
@@ -106,6 +93,11 @@ func (ms *MilvusStore) Create(ctx context.Context, p Product) error {
 	return ms.BatchCreate(ctx, []*Product{&p})
 }
 
+// the upsert of milvus is visible to the next search.
+func (ms *MilvusStore) Upsert(ctx context.Context, p *Product) error {
+	return ms.BatchCreate(ctx, []*Product{p})
+}
+
 func (ms *MilvusStore) Update(ctx context.Context, p Product) error {
 	if err := ms.Delete(ctx, p); err != nil {
 		return err
@@ -118,13 +110,33 @@ func (ms *MilvusStore) Update(ctx context.Context, p Product) error {
 }
 
 func (ms *MilvusStore) Delete(ctx context.Context, p Product) error {
-	dim := len(p.Vector)
-	col, err := ms.collection(ctx, dim)
+	expr := fmt.Sprintf("id in [%s]", sliceToStr([]string{p.Id}))
+
+	if dim := len(p.Vector); dim > 0 {
+		col, err := ms.collection(ctx, dim)
+		if err != nil {
+			return err
+		}
+		return ms.client.Delete(ctx, col, PARTITION, expr)
+	}
+
+	// the vector is not kept in es, so without it the collection(dim) is unknown,
+	// delete from every product vector collection instead.
+	cols, err := ms.client.ListCollections(ctx)
 	if err != nil {
 		return err
 	}
-	expr := fmt.Sprintf("id in [%s]", sliceToStr([]string{p.Id}))
-	return ms.client.Delete(ctx, col, PARTITION, expr)
+
+	for _, col := range cols {
+		if !strings.HasPrefix(col.Name, collectionPrefix) {
+			continue
+		}
+		if err := ms.client.Delete(ctx, col.Name, PARTITION, expr); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (ms *MilvusStore) Query(ctx context.Context, request QueryVectorRequest) (QueryVectorResponse, error) {
@@ -178,7 +190,7 @@ func (ms *MilvusStore) Query(ctx context.Context, request QueryVectorRequest) (Q
 }
 
 func (ms *MilvusStore) collection(ctx context.Context, dim int) (string, error) {
-	colName := fmt.Sprintf("product_vector_%d", dim)
+	colName := fmt.Sprintf("%s%d", collectionPrefix, dim)
 
 	if exist, err := ms.client.HasCollection(ctx, colName); err != nil {
 		return "", err
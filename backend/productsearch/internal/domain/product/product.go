@@ -1,20 +1,37 @@
 package product
 
 import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"github.com/ringbrew/newaim/productsearch/internal/domain/embedding"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"time"
 )
 
+var (
+	ErrProductNotFound = errors.New("product not found")
+	ErrProductExists   = errors.New("product of the sku already exists")
+)
+
 type Product struct {
-	Id          string           `bson:"id" json:"id"`
-	CreateTime  time.Time        `bson:"createTime" json:"createTime"`
-	UpdateTime  time.Time        `bson:"updateTime" json:"updateTime"`
-	SKU         string           `bson:"sku" json:"sku"`
-	Title       string           `bson:"title" json:"title"`
-	Description string           `bson:"description" json:"description"`
+	Id          string    `bson:"id" json:"id"`
+	CreateTime  time.Time `bson:"createTime" json:"createTime"`
+	UpdateTime  time.Time `bson:"updateTime" json:"updateTime"`
+	SKU         string    `bson:"sku" json:"sku"`
+	Title       string    `bson:"title" json:"title"`
+	Description string    `bson:"description" json:"description"`
+	Category    string    `bson:"category" json:"category,omitempty"`
+	Brand       string    `bson:"brand" json:"brand,omitempty"`
+	// Popularity sales or click signal, boosts the relevance when enabled in config.
+	Popularity  float64          `bson:"popularity" json:"popularity,omitempty"`
 	Vector      embedding.Vector `bson:"vector" json:"vector,omitempty"`
 	Score       float64          `bson:"-" json:"score,omitempty"`
+	ScoreDetail *ScoreDetail     `bson:"-" json:"scoreDetail,omitempty"`
+	// Rule merchandising rule which moved the product.
+	Rule *RuleMark `bson:"-" json:"rule,omitempty"`
+	// Highlight matched fragments by field, only set by a lexical search asking for it.
+	Highlight map[string][]string `bson:"-" json:"highlight,omitempty"`
 }
 
 func (p *Product) GetId() string {
@@ -43,3 +60,11 @@ func NewIdGenerator() IdGenerator {
 func (g *BsonIdGenerator) NewId() string {
 	return primitive.NewObjectID().Hex()
 }
+
+/*
+@desc: stable id of a catalog product, same length as the bson object id.
+*/
+func skuId(sku string) string {
+	sum := md5.Sum([]byte(sku))
+	return hex.EncodeToString(sum[:])[:24]
+}
@@ -0,0 +1,100 @@
+package product
+
+import (
+	"errors"
+)
+
+const (
+	SearchModeFallback = "fallback"
+	SearchModeHybrid   = "hybrid"
+
+	defaultVectorMaxWindow = 500
+)
+
+var ErrPageTooDeep = errors.New("page too deep")
+
+type QueryRequest struct {
+	Keyword string
+	From    int64
+	Size    int64
+	// Mode fallback only asks the vector store when the lexical search hits nothing,
+	// hybrid always asks both and fuses the result.
+	Mode   string
+	Hybrid HybridOption
+	// Filter narrows both the lexical and the vector result, an empty keyword lists by filter only.
+	Filter    Filter
+	Facets    []string
+	FacetSize int
+	// Highlight asks for the matched fragments of title and description.
+	Highlight bool
+	// AutoCorrect nil follows the config.
+	AutoCorrect *bool
+	// Sort empty sorts by relevance.
+	Sort []SortField
+	// Cursor CursorStart or the NextCursor of the previous page, From is ignored with a cursor.
+	Cursor string
+
+	cursor *Cursor
+}
+
+type QueryResponse struct {
+	Data   []Product
+	Total  int64
+	Facets map[string][]FacetBucket
+	// SuggestedQuery the corrected spelling of a zero result keyword,
+	// AutoCorrected tells the data is already the result of it.
+	SuggestedQuery string
+	AutoCorrected  bool
+	// Estimated tells the total is a lower bound, vector results are only counted up to a window.
+	Estimated bool
+	// NextCursor empty when there is no more page.
+	NextCursor string
+	// Redirect of a matched merchandising rule, the frontend leaves the result for it.
+	Redirect *Redirect
+}
+
+/*
+@desc: fill the unset query option with config.
+*/
+func (uc *UseCase) withDefault(req QueryRequest) QueryRequest {
+	c := uc.ctx.Config.Search
+
+	if req.Mode == "" {
+		req.Mode = c.Mode
+	}
+	if req.Mode == "" {
+		req.Mode = SearchModeFallback
+	}
+
+	if req.Hybrid.Fusion == "" {
+		req.Hybrid.Fusion = c.Fusion
+	}
+	if req.Hybrid.Fusion == "" {
+		req.Hybrid.Fusion = FusionRRF
+	}
+
+	if req.Hybrid.RRFK <= 0 {
+		req.Hybrid.RRFK = c.RRFK
+	}
+	if req.Hybrid.RRFK <= 0 {
+		req.Hybrid.RRFK = defaultRRFK
+	}
+
+	if req.AutoCorrect == nil {
+		autoCorrect := c.AutoCorrect
+		req.AutoCorrect = &autoCorrect
+	}
+
+	lexicalWeight, vectorWeight := c.LexicalWeight, c.VectorWeight
+	if lexicalWeight == 0 && vectorWeight == 0 {
+		lexicalWeight, vectorWeight = 1, 1
+	}
+	if req.Hybrid.LexicalWeight == nil {
+		req.Hybrid.LexicalWeight = &lexicalWeight
+	}
+	if req.Hybrid.VectorWeight == nil {
+		req.Hybrid.VectorWeight = &vectorWeight
+	}
+
+	return req
+}
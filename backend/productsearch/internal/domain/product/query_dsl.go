@@ -0,0 +1,373 @@
+package product
+
+import (
+	"strings"
+	"unicode"
+)
+
+/*
+@desc: keyword language of the search box.
+
+	red shoes               both words, in any field
+	"running shoe"          phrase
+	title:nike desc:"air"   qualified by field, sku: looks up the normalized code, a trailing * makes it a prefix
+	-kids -title:"slip on"  exclusion
+	red OR blue, (a OR b) c OR group, words next to each other are all required
+
+a malformed input never fails, an unclosed quote or parenthesis ends with the input.
+*/
+
+const (
+	nodeTerm = iota
+	nodeAnd
+	nodeOr
+)
+
+// qualifier to es field.
+var queryFields = map[string]string{
+	"title": "title",
+	"desc":  "description",
+	"sku":   "sku",
+}
+
+type queryNode struct {
+	kind     int
+	field    string
+	text     string
+	phrase   bool
+	negate   bool
+	children []*queryNode
+}
+
+const (
+	tokenAtom = iota
+	tokenOpen
+	tokenClose
+	tokenOr
+)
+
+type queryToken struct {
+	kind   int
+	field  string
+	text   string
+	phrase bool
+	negate bool
+}
+
+func tokenizeQuery(input string) []queryToken {
+	runes := []rune(input)
+	result := make([]queryToken, 0)
+
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		if runes[i] == ')' {
+			result = append(result, queryToken{kind: tokenClose})
+			i++
+			continue
+		}
+
+		t := queryToken{kind: tokenAtom}
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			t.negate = true
+			i++
+		}
+
+		if runes[i] == '(' {
+			t.kind = tokenOpen
+			result = append(result, t)
+			i++
+			continue
+		}
+
+		if colon := strings.IndexRune(string(runes[i:]), ':'); colon > 0 {
+			name := string(runes[i:])[:colon]
+			if field, found := queryFields[strings.ToLower(name)]; found {
+				t.field = field
+				i += len([]rune(name)) + 1
+			}
+		}
+
+		if i < len(runes) && runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			t.text = strings.TrimSpace(string(runes[i+1 : end]))
+			t.phrase = true
+			i = end + 1
+		} else {
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && runes[end] != '(' && runes[end] != ')' {
+				end++
+			}
+			t.text = string(runes[i:end])
+			i = end
+		}
+
+		if t.text == "" {
+			continue
+		}
+
+		if t.text == "OR" && !t.phrase && t.field == "" && !t.negate {
+			t.kind = tokenOr
+		} else if t.text == "AND" && !t.phrase && t.field == "" && !t.negate {
+			// words are required anyway.
+			continue
+		}
+
+		result = append(result, t)
+	}
+
+	return result
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+/*
+@desc: parse the keyword, nil when nothing is left to search.
+*/
+func parseQuery(input string) *queryNode {
+	p := &queryParser{tokens: tokenizeQuery(input)}
+
+	root := &queryNode{kind: nodeAnd}
+	for p.pos < len(p.tokens) {
+		root.children = append(root.children, p.parseAnd())
+		// stray close parenthesis.
+		if p.pos < len(p.tokens) {
+			p.pos++
+		}
+	}
+
+	return simplify(root)
+}
+
+func (p *queryParser) parseAnd() *queryNode {
+	node := &queryNode{kind: nodeAnd}
+	for p.pos < len(p.tokens) && p.tokens[p.pos].kind != tokenClose {
+		if child := p.parseOr(); child != nil {
+			node.children = append(node.children, child)
+		}
+	}
+	return node
+}
+
+func (p *queryParser) parseOr() *queryNode {
+	node := &queryNode{kind: nodeOr}
+	for {
+		if child := p.parseUnary(); child != nil {
+			node.children = append(node.children, child)
+		}
+
+		if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOr {
+			p.pos++
+			continue
+		}
+		return node
+	}
+}
+
+func (p *queryParser) parseUnary() *queryNode {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+
+	t := p.tokens[p.pos]
+	p.pos++
+
+	switch t.kind {
+	case tokenAtom:
+		return &queryNode{
+			kind:   nodeTerm,
+			field:  t.field,
+			text:   t.text,
+			phrase: t.phrase,
+			negate: t.negate,
+		}
+	case tokenOpen:
+		node := p.parseAnd()
+		if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenClose {
+			p.pos++
+		}
+		node.negate = t.negate
+		return node
+	default:
+		// leading or doubled OR.
+		return nil
+	}
+}
+
+/*
+@desc: drop empty group and unwrap group of one.
+*/
+func simplify(node *queryNode) *queryNode {
+	if node == nil || node.kind == nodeTerm {
+		return node
+	}
+
+	children := make([]*queryNode, 0, len(node.children))
+	for _, v := range node.children {
+		if c := simplify(v); c != nil {
+			children = append(children, c)
+		}
+	}
+
+	switch len(children) {
+	case 0:
+		return nil
+	case 1:
+		child := children[0]
+		child.negate = child.negate != node.negate
+		return child
+	default:
+		node.children = children
+		return node
+	}
+}
+
+/*
+@desc: plain words only, no qualifier, phrase, exclusion or OR group.
+*/
+func (n *queryNode) plain() bool {
+	if n.negate || n.kind == nodeOr {
+		return false
+	}
+	if n.kind == nodeTerm {
+		return n.field == "" && !n.phrase
+	}
+
+	for _, v := range n.children {
+		if !v.plain() {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+@desc: es query of the node, the negate flag is left to the parent.
+*/
+func (n *queryNode) clause() map[string]interface{} {
+	switch n.kind {
+	case nodeAnd:
+		must := make([]map[string]interface{}, 0)
+		mustNot := make([]map[string]interface{}, 0)
+		for _, v := range n.children {
+			if v.negate {
+				mustNot = append(mustNot, v.clause())
+			} else {
+				must = append(must, v.clause())
+			}
+		}
+
+		boolQuery := map[string]interface{}{}
+		if len(must) > 0 {
+			boolQuery["must"] = must
+		}
+		if len(mustNot) > 0 {
+			boolQuery["must_not"] = mustNot
+		}
+		return map[string]interface{}{
+			"bool": boolQuery,
+		}
+	case nodeOr:
+		should := make([]map[string]interface{}, 0, len(n.children))
+		for _, v := range n.children {
+			should = append(should, v.query())
+		}
+		return map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should":               should,
+				"minimum_should_match": 1,
+			},
+		}
+	default:
+		return n.termClause()
+	}
+}
+
+/*
+@desc: es query of the node with the negate flag applied.
+*/
+func (n *queryNode) query() map[string]interface{} {
+	if !n.negate {
+		return n.clause()
+	}
+
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must_not": []map[string]interface{}{n.clause()},
+		},
+	}
+}
+
+func (n *queryNode) termClause() map[string]interface{} {
+	switch n.field {
+	case "sku":
+		if strings.HasSuffix(n.text, "*") {
+			return map[string]interface{}{
+				"prefix": map[string]interface{}{
+					"sku.normalized": NormalizeSku(n.text),
+				},
+			}
+		}
+		return map[string]interface{}{
+			"term": map[string]interface{}{
+				"sku.normalized": NormalizeSku(n.text),
+			},
+		}
+	case "title", "description":
+		if n.phrase {
+			return map[string]interface{}{
+				"match_phrase": map[string]interface{}{
+					n.field: n.text,
+				},
+			}
+		}
+		return map[string]interface{}{
+			"match": map[string]interface{}{
+				n.field: map[string]interface{}{
+					"query":    n.text,
+					"operator": "and",
+				},
+			},
+		}
+	}
+
+	// unqualified text looks at every field.
+	multiMatch := map[string]interface{}{
+		"query":  n.text,
+		"fields": []string{"title", "description"},
+	}
+	if n.phrase {
+		multiMatch["type"] = "phrase"
+	} else {
+		multiMatch["operator"] = "and"
+	}
+
+	should := []map[string]interface{}{
+		{
+			"multi_match": multiMatch,
+		},
+	}
+	if sku := NormalizeSku(n.text); sku != "" {
+		should = append(should, map[string]interface{}{
+			"term": map[string]interface{}{
+				"sku.normalized": sku,
+			},
+		})
+	}
+
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should":               should,
+			"minimum_should_match": 1,
+		},
+	}
+}
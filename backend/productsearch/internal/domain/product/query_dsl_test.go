@@ -0,0 +1,87 @@
+package product
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"", "<nil>"},
+		{"OR AND", "<nil>"},
+		{"red", "red"},
+		{"red shoes", "and(red shoes)"},
+		{"red AND shoes", "and(red shoes)"},
+		{`title:nike desc:"air max"`, `and(title:nike description:"air max")`},
+		{"red OR blue shoes", "and(or(red blue) shoes)"},
+		{"(red OR blue) -kids", "and(or(red blue) -kids)"},
+		{`-title:"slip on" sku:V539*`, `and(-title:"slip on" sku:V539*)`},
+		{"-(a b)", "-and(a b)"},
+		{`"unclosed phrase`, `"unclosed phrase"`},
+		{"(a OR b", "or(a b)"},
+		{"a) b", "and(a b)"},
+		{"http://x", "http://x"},
+	}
+
+	for _, c := range cases {
+		if got := dumpQuery(parseQuery(c.input)); got != c.want {
+			t.Errorf("parseQuery(%q) = %s, want %s", c.input, got, c.want)
+		}
+	}
+}
+
+func TestQueryClause(t *testing.T) {
+	data, err := json.Marshal(parseQuery(`title:nike -sku:v539-nik*`).query())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"bool":{"must":[{"match":{"title":{"operator":"and","query":"nike"}}}],"must_not":[{"prefix":{"sku.normalized":"v539nik"}}]}}`
+	if string(data) != want {
+		t.Errorf("unexpected clause: %s", data)
+	}
+
+	if parseQuery("red shoes").plain() != true || parseQuery(`"red shoes"`).plain() != false {
+		t.Error("unexpected plain detection")
+	}
+}
+
+func dumpQuery(n *queryNode) string {
+	if n == nil {
+		return "<nil>"
+	}
+
+	prefix := ""
+	if n.negate {
+		prefix = "-"
+	}
+
+	switch n.kind {
+	case nodeTerm:
+		text := n.text
+		if n.phrase {
+			text = `"` + text + `"`
+		}
+		if n.field != "" {
+			text = n.field + ":" + text
+		}
+		return prefix + text
+	default:
+		name := "and"
+		if n.kind == nodeOr {
+			name = "or"
+		}
+
+		result := prefix + name + "("
+		for i, v := range n.children {
+			if i > 0 {
+				result += " "
+			}
+			result += dumpQuery(v)
+		}
+		return result + ")"
+	}
+}
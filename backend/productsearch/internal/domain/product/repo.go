@@ -8,6 +8,7 @@ import (
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/ringbrew/newaim/productsearch/internal/conf"
 	"github.com/ringbrew/newaim/productsearch/internal/domain"
 	"io/ioutil"
 	"log"
@@ -19,11 +20,13 @@ import (
 const productIndex = "newaim_product_sku_index"
 
 type ESResponse struct {
-	Took         int                    `json:"took"`
-	TimedOut     bool                   `json:"timed_out"`
-	Shards       ESShardResponse        `json:"_shards"`
-	Hits         ESHitResponse          `json:"hits"`
-	Aggregations map[string]interface{} `json:"aggregations"`
+	Took         int                         `json:"took"`
+	TimedOut     bool                        `json:"timed_out"`
+	Shards       ESShardResponse             `json:"_shards"`
+	Hits         ESHitResponse               `json:"hits"`
+	Aggregations map[string]interface{}      `json:"aggregations"`
+	Suggest      map[string][]ESSuggestEntry `json:"suggest"`
+	PitId        string                      `json:"pit_id"`
 }
 
 type ESShardResponse struct {
@@ -34,13 +37,15 @@ type ESShardResponse struct {
 }
 
 type Hit struct {
-	Index  string                 `json:"_index"`
-	Type   string                 `json:"_type"`
-	Id     string                 `json:"_id"`
-	Source map[string]interface{} `json:"_source"`
-	Fields map[string]interface{} `json:"fields"`
-	Sort   []int                  `json:"sort"`
-	Score  float64                `json:"_score"`
+	Index       string                 `json:"_index"`
+	Type        string                 `json:"_type"`
+	Id          string                 `json:"_id"`
+	Source      map[string]interface{} `json:"_source"`
+	Fields      map[string]interface{} `json:"fields"`
+	Highlight   map[string][]string    `json:"highlight"`
+	Sort        []json.RawMessage      `json:"sort"`
+	Score       float64                `json:"_score"`
+	Explanation json.RawMessage        `json:"_explanation"`
 }
 
 type ESHitResponse struct {
@@ -54,18 +59,29 @@ type ESHitResponse struct {
 type repo struct {
 	es        *elasticsearch.Client
 	bulkIndex map[string]esutil.BulkIndexer
+	mapping   map[string]interface{}
 }
 
 func newRepo(ctx *domain.UseCaseContext) *repo {
 	r := &repo{
 		es:        ctx.ElasticSearch,
 		bulkIndex: make(map[string]esutil.BulkIndexer),
+		mapping:   productMapping,
 	}
 
+	if ctx.Config.VectorStore.Type == VectorStoreElastic {
+		r.mapping = withVectorMapping(productMapping, ctx.Config.VectorStore)
+	}
+
+	// productIndex is an alias of the versioned physical index, see Rebuild.
 	if exist, err := r.CheckIndexExist(productIndex); err != nil {
 		log.Fatal(err.Error())
 	} else if !exist {
-		if err := r.CreateIndexES(productIndex, productMapping); err != nil {
+		index := newVersionIndex()
+		if err := r.CreateIndexES(index, r.mapping); err != nil {
+			log.Fatal(err.Error())
+		}
+		if err := r.SwapAlias(context.Background(), index); err != nil {
 			log.Fatal(err.Error())
 		}
 	}
@@ -116,14 +132,33 @@ func (r *repo) CountIndex(indexName string) (int64, error) {
 	return count.Count, nil
 }
 
-func (r *repo) CreateMany(ctx context.Context, product []*Product) error {
+func (r *repo) CloseBulkIndex(ctx context.Context, indexName string) error {
+	bi, found := r.bulkIndex[indexName]
+	if !found {
+		return nil
+	}
+
+	delete(r.bulkIndex, indexName)
+
+	if err := bi.Close(ctx); err != nil {
+		return err
+	}
+
+	if stats := bi.Stats(); stats.NumFailed > 0 {
+		log.Printf("bulk index-[%s] finish with %d failed item", indexName, stats.NumFailed)
+	}
+
+	return nil
+}
+
+func (r *repo) CreateMany(ctx context.Context, index string, product []*Product) error {
 	for _, v := range product {
 		data, err := json.Marshal(v)
 		if err != nil {
 			return err
 		}
 
-		if err := r.bulkIndex[productIndex].Add(
+		if err := r.bulkIndex[index].Add(
 			ctx,
 			esutil.BulkIndexerItem{
 				//index, create, delete, update
@@ -147,84 +182,303 @@ func (r *repo) CreateMany(ctx context.Context, product []*Product) error {
 	return nil
 }
 
-func (r *repo) SearchById(ctx context.Context, id []string) ([]Product, error) {
-	query := map[string]interface{}{
-		"sort": []interface{}{
-			map[string]interface{}{
-				"_score": "desc",
-			},
-		},
+/*
+@desc: create the product, ErrProductExists when a product already has its id.
+*/
+func (r *repo) Save(ctx context.Context, p *Product) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
 
-		"fields": []string{"id", "createTime", "updateTime", "sku", "title", "description"},
+	req := esapi.IndexRequest{
+		Index:      productIndex,
+		DocumentID: p.Id,
+		Body:       bytes.NewReader(data),
+		OpType:     "create",
+		Refresh:    "wait_for",
 	}
 
-	query["query"] = map[string]interface{}{
-		"term": map[string]interface{}{
-			"id": id,
-		},
+	resp, err := req.Do(ctx, r.es)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return ErrProductExists
+	}
+
+	if resp.IsError() {
+		return fmt.Errorf("error index product-[%s] to es, status[%s]", p.Id, resp.Status())
 	}
 
-	result, _, err := r.searchProductByQuery(0, int64(len(id)), query)
+	return nil
+}
+
+/*
+@desc: replace the product fields, an empty optional field is cleared. the vector is kept,
+the vector store writes it apart.
+*/
+func (r *repo) Update(ctx context.Context, p *Product) error {
+	return r.updateDoc(ctx, productIndex, p.Id, map[string]interface{}{
+		"doc": productDoc(p),
+	})
+}
+
+func productDoc(p *Product) map[string]interface{} {
+	doc := map[string]interface{}{
+		"id":          p.Id,
+		"createTime":  p.CreateTime,
+		"updateTime":  p.UpdateTime,
+		"sku":         p.SKU,
+		"title":       p.Title,
+		"description": p.Description,
+		"category":    nil,
+		"brand":       nil,
+		"popularity":  nil,
+	}
+	if p.Category != "" {
+		doc["category"] = p.Category
+	}
+	if p.Brand != "" {
+		doc["brand"] = p.Brand
+	}
+	if p.Popularity != 0 {
+		doc["popularity"] = p.Popularity
+	}
+	return doc
+}
+
+func (r *repo) updateDoc(ctx context.Context, index string, id string, body map[string]interface{}) error {
+	data, err := json.Marshal(body)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return result, nil
+
+	req := esapi.UpdateRequest{
+		Index:      index,
+		DocumentID: id,
+		Body:       bytes.NewReader(data),
+		Refresh:    "wait_for",
+	}
+
+	resp, err := req.Do(ctx, r.es)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrProductNotFound
+	}
+
+	if resp.IsError() {
+		return fmt.Errorf("error update product-[%s] to es, status[%s]", id, resp.Status())
+	}
+
+	return nil
+}
+
+func (r *repo) Get(ctx context.Context, id string) (Product, error) {
+	result, err := r.SearchById(ctx, []string{id})
+	if err != nil {
+		return Product{}, err
+	}
+
+	if len(result) == 0 {
+		return Product{}, ErrProductNotFound
+	}
+
+	return result[0], nil
+}
+
+func (r *repo) Delete(ctx context.Context, id string) error {
+	req := esapi.DeleteRequest{
+		Index:      productIndex,
+		DocumentID: id,
+		Refresh:    "wait_for",
+	}
+
+	resp, err := req.Do(ctx, r.es)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrProductNotFound
+	}
+
+	if resp.IsError() {
+		return fmt.Errorf("error delete product-[%s] from es, status[%s]", id, resp.Status())
+	}
+
+	return nil
 }
 
-func (r *repo) Search(ctx context.Context, keyword string, from, size int64, isSku ...bool) ([]Product, int64, error) {
+var productFields = []string{"id", "createTime", "updateTime", "sku", "title", "description", "category", "brand", "popularity"}
+
+type SearchOption struct {
+	From      int64
+	Size      int64
+	IsSku     bool
+	Filter    Filter
+	Facets    []string
+	FacetSize int
+	// Highlight nil means no highlight.
+	Highlight *HighlightOption
+	// Cursor pages by point in time and search_after instead of from.
+	Cursor *Cursor
+	Sort   []SortField
+	// Boost nil means pure text relevance.
+	Boost *BoostOption
+}
+
+type SearchResult struct {
+	Data   []Product
+	Total  int64
+	Facets map[string][]FacetBucket
+
+	aggregations map[string]interface{}
+	pitId        string
+	lastSort     []json.RawMessage
+}
+
+/*
+@desc: products of the id list in no particular order, the caller keeps its own ranking.
+*/
+func (r *repo) SearchById(ctx context.Context, id []string, filter ...Filter) ([]Product, error) {
+	return r.searchByTerms(ctx, "id", id, filter...)
+}
+
+/*
+@desc: products of the sku list in no particular order.
+*/
+func (r *repo) SearchBySku(ctx context.Context, sku []string, filter ...Filter) ([]Product, error) {
+	return r.searchByTerms(ctx, "sku", sku, filter...)
+}
+
+func (r *repo) searchByTerms(ctx context.Context, field string, values []string, filter ...Filter) ([]Product, error) {
 	query := map[string]interface{}{
-		"sort": []interface{}{
-			map[string]interface{}{
-				"_score": "desc",
-			},
-		},
+		"fields": productFields,
+	}
 
-		"fields": []string{"id", "createTime", "updateTime", "sku", "title", "description"},
+	termsQuery := map[string]interface{}{
+		"terms": map[string]interface{}{
+			field: values,
+		},
 	}
 
-	if len(isSku) > 0 && isSku[0] {
+	if len(filter) > 0 {
 		query["query"] = map[string]interface{}{
 			"bool": map[string]interface{}{
-				"should": []map[string]interface{}{
-					{
-						"term": map[string]interface{}{
-							"sku": keyword,
-						},
-					},
-				},
+				"must":   termsQuery,
+				"filter": filter[0].clauses(),
 			},
 		}
 	} else {
-		query["query"] = map[string]interface{}{
-			"bool": map[string]interface{}{
-				"should": []map[string]interface{}{
-					{
-						"term": map[string]interface{}{
-							"sku": keyword,
-						},
-					},
-					{
-						"match": map[string]interface{}{
-							"title": keyword,
-						},
+		query["query"] = termsQuery
+	}
+
+	result, err := r.searchProductByQuery(ctx, 0, int64(len(values)), query)
+	if err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+func (r *repo) Search(ctx context.Context, keyword string, opt SearchOption) (SearchResult, error) {
+	query := map[string]interface{}{
+		"sort":   sortClauses(opt.Sort),
+		"fields": productFields,
+	}
+
+	var should []map[string]interface{}
+	if keyword == "" {
+		// listing by filter only.
+	} else if opt.IsSku {
+		// an exact code ranks above the variants sharing its prefix, both above the text match
+		// kept for a keyword taken as sku by mistake.
+		normalized := NormalizeSku(keyword)
+		should = []map[string]interface{}{
+			{
+				"term": map[string]interface{}{
+					"sku.normalized": map[string]interface{}{
+						"value": normalized,
+						"boost": 20,
 					},
-					{
-						"match": map[string]interface{}{
-							"description": keyword,
-						},
+				},
+			},
+			{
+				"prefix": map[string]interface{}{
+					"sku.normalized": map[string]interface{}{
+						"value": normalized,
+						"boost": 10,
 					},
 				},
 			},
 		}
+		if node := parseQuery(keyword); node != nil {
+			should = append(should, node.query())
+		}
+	} else if node := parseQuery(keyword); node != nil {
+		should = []map[string]interface{}{node.query()}
+	}
+
+	boolQuery := map[string]interface{}{
+		"filter": opt.Filter.clauses(),
+	}
+	if len(should) > 0 {
+		boolQuery["should"] = should
+		boolQuery["minimum_should_match"] = 1
+	}
+
+	query["query"] = map[string]interface{}{
+		"bool": boolQuery,
+	}
+
+	// an exact sku lookup is not reordered.
+	if opt.Boost != nil && !opt.IsSku {
+		query["query"] = opt.Boost.wrap(query["query"].(map[string]interface{}))
+	}
+
+	if aggs := facetAggs(opt.Facets, opt.FacetSize); len(aggs) > 0 {
+		query["aggs"] = aggs
+	}
+
+	if opt.Highlight != nil && keyword != "" {
+		query["highlight"] = opt.Highlight.query()
+	}
+
+	if opt.Cursor != nil {
+		// _shard_doc breaks the last tie, search_after needs a total order.
+		query["sort"] = append(sortClauses(opt.Sort), map[string]interface{}{
+			"_shard_doc": "asc",
+		})
+		query["pit"] = map[string]interface{}{
+			"id":         opt.Cursor.PitId,
+			"keep_alive": cursorKeepAlive,
+		}
+		if len(opt.Cursor.SearchAfter) > 0 {
+			query["search_after"] = opt.Cursor.SearchAfter
+		}
+		opt.From = 0
+	}
+
+	result, err := r.searchProductByQuery(ctx, opt.From, opt.Size, query)
+	if err != nil {
+		return SearchResult{}, err
 	}
 
-	return r.searchProductByQuery(from, size, query)
+	result.Facets = parseFacets(result.aggregations)
+	return result, nil
 }
 
-func (r *repo) searchProductByQuery(from, size int64, query map[string]interface{}) ([]Product, int64, error) {
-	data, err := r.searchFromES(productIndex, from, size, query)
+func (r *repo) searchProductByQuery(ctx context.Context, from, size int64, query map[string]interface{}) (SearchResult, error) {
+	data, err := r.searchFromES(ctx, productIndex, from, size, query)
 	if err != nil {
-		return nil, 0, err
+		return SearchResult{}, err
 	}
 
 	parseString := func(input interface{}) string {
@@ -238,6 +492,17 @@ func (r *repo) searchProductByQuery(from, size int64, query map[string]interface
 		return ""
 	}
 
+	parseFloat := func(input interface{}) float64 {
+		if val, ok := input.([]interface{}); ok {
+			if len(val) > 0 {
+				if fVal, ok := val[0].(float64); ok {
+					return fVal
+				}
+			}
+		}
+		return 0
+	}
+
 	parseDate := func(input interface{}) time.Time {
 		if val, ok := input.([]interface{}); ok {
 			if len(val) > 0 {
@@ -264,7 +529,11 @@ func (r *repo) searchProductByQuery(from, size int64, query map[string]interface
 			Title:       parseString(hit.Fields["title"]),
 			SKU:         parseString(hit.Fields["sku"]),
 			Description: parseString(hit.Fields["description"]),
+			Category:    parseString(hit.Fields["category"]),
+			Brand:       parseString(hit.Fields["brand"]),
+			Popularity:  parseFloat(hit.Fields["popularity"]),
 			Score:       hit.Score,
+			Highlight:   hit.Highlight,
 		}
 	}
 
@@ -273,34 +542,58 @@ func (r *repo) searchProductByQuery(from, size int64, query map[string]interface
 		result = append(result, hitsToProduct(v))
 	}
 
-	return result, data.Hits.Total.Value, nil
+	var lastSort []json.RawMessage
+	if len(data.Hits.Hits) > 0 {
+		lastSort = data.Hits.Hits[len(data.Hits.Hits)-1].Sort
+	}
+
+	return SearchResult{
+		Data:         result,
+		Total:        data.Hits.Total.Value,
+		aggregations: data.Aggregations,
+		pitId:        data.PitId,
+		lastSort:     lastSort,
+	}, nil
 }
 
-func (r *repo) searchFromES(index string, from, size int64, query map[string]interface{}) (ESResponse, error) {
+func (r *repo) searchFromES(ctx context.Context, index string, from, size int64, query map[string]interface{}) (ESResponse, error) {
 	var buf bytes.Buffer
 	var result ESResponse
 	query["from"] = from
 	query["size"] = size
-	//query["search_after"] = []int64{from}
+
+	// explanation is costly, it is only computed for an explained query.
+	e := explainFrom(ctx)
+	if e != nil {
+		query["explain"] = true
+	}
 
 	if err := json.NewEncoder(&buf).Encode(query); err != nil {
 		return result, err
 	}
 
-	res, err := r.es.Search(
-		r.es.Search.WithContext(context.Background()),
-		r.es.Search.WithIndex(index),
+	opts := []func(*esapi.SearchRequest){
+		r.es.Search.WithContext(ctx),
 		r.es.Search.WithBody(&buf),
 		r.es.Search.WithTrackTotalHits(true),
-		r.es.Search.WithPretty(),
-		r.es.Search.WithExplain(true),
-	)
+	}
+	// a point in time search names no index, the point in time has it.
+	if _, found := query["pit"]; !found {
+		opts = append(opts, r.es.Search.WithIndex(index))
+	}
+
+	res, err := r.es.Search(opts...)
 	if err != nil {
 		return result, err
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
+		// the cursor of a point in time search is a client error, it starts over.
+		if _, found := query["pit"]; found && cursorRejected(res.StatusCode) {
+			return result, ErrInvalidCursor
+		}
+
 		var e map[string]interface{}
 		if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
 			return result, fmt.Errorf("error parsing the error response body: %s", err.Error())
@@ -322,6 +615,7 @@ func (r *repo) searchFromES(index string, from, size int64, query map[string]int
 		return result, fmt.Errorf("error parsing the response body: %s", err)
 	}
 
+	e.search(index, query, result)
 	return result, nil
 }
 
@@ -329,6 +623,24 @@ func (r *repo) searchFromES(index string, from, size int64, query map[string]int
 @desc: product mapping
 */
 var productMapping = map[string]interface{}{
+	"settings": map[string]interface{}{
+		"analysis": map[string]interface{}{
+			"char_filter": map[string]interface{}{
+				"sku_separator": map[string]interface{}{
+					"type":        "pattern_replace",
+					"pattern":     "[^A-Za-z0-9]",
+					"replacement": "",
+				},
+			},
+			"normalizer": map[string]interface{}{
+				"sku_normalizer": map[string]interface{}{
+					"type":        "custom",
+					"char_filter": []string{"sku_separator"},
+					"filter":      []string{"lowercase"},
+				},
+			},
+		},
+	},
 	"mappings": map[string]interface{}{
 		"properties": map[string]interface{}{
 			"id": map[string]interface{}{
@@ -344,17 +656,76 @@ var productMapping = map[string]interface{}{
 			},
 			"sku": map[string]interface{}{
 				"type": "keyword",
+				"fields": map[string]interface{}{
+					"normalized": map[string]interface{}{
+						"type":       "keyword",
+						"normalizer": "sku_normalizer",
+					},
+					"sayt": map[string]interface{}{
+						"type": "search_as_you_type",
+					},
+				},
 			},
 			"title": map[string]interface{}{
 				"type": "text",
+				"fields": map[string]interface{}{
+					"sayt": map[string]interface{}{
+						"type": "search_as_you_type",
+					},
+				},
 			},
 			"description": map[string]interface{}{
 				"type": "text",
 			},
+			"category": map[string]interface{}{
+				"type": "keyword",
+			},
+			"brand": map[string]interface{}{
+				"type": "keyword",
+			},
+			"popularity": map[string]interface{}{
+				"type": "float",
+			},
 		},
 	},
 }
 
+/*
+@desc: copy of mapping with the dense_vector field used by the es vector store.
+*/
+func withVectorMapping(mapping map[string]interface{}, c conf.VectorStore) map[string]interface{} {
+	vectorMapping := map[string]interface{}{
+		"type": "dense_vector",
+		"dims": c.Dims,
+	}
+
+	// approximate knn needs an indexed vector, exact script_score works with doc values only.
+	if !c.Exact {
+		vectorMapping["index"] = true
+		if c.Metric == MetricIP {
+			vectorMapping["similarity"] = "dot_product"
+		} else {
+			vectorMapping["similarity"] = "l2_norm"
+		}
+	}
+
+	properties := make(map[string]interface{})
+	for k, v := range mapping["mappings"].(map[string]interface{})["properties"].(map[string]interface{}) {
+		properties[k] = v
+	}
+	properties[VectorField] = vectorMapping
+
+	result := make(map[string]interface{})
+	for k, v := range mapping {
+		result[k] = v
+	}
+	result["mappings"] = map[string]interface{}{
+		"properties": properties,
+	}
+
+	return result
+}
+
 func (r *repo) CheckIndexExist(idx string) (bool, error) {
 	req := esapi.IndicesExistsRequest{
 		Index: []string{idx},
@@ -372,6 +743,24 @@ func (r *repo) CheckIndexExist(idx string) (bool, error) {
 	}
 }
 
+func (r *repo) RefreshIndex(ctx context.Context, idx string) error {
+	req := esapi.IndicesRefreshRequest{
+		Index: []string{idx},
+	}
+
+	resp, err := req.Do(ctx, r.es)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		return fmt.Errorf("error refresh index-[%s], status[%s]", idx, resp.Status())
+	}
+
+	return nil
+}
+
 func (r *repo) CreateIndexES(idx string, mapping map[string]interface{}) error {
 	b, err := json.Marshal(mapping)
 	if err != nil {
@@ -396,6 +785,10 @@ func (r *repo) CreateIndexES(idx string, mapping map[string]interface{}) error {
 
 	log.Println(string(data))
 
+	if resp.IsError() {
+		return fmt.Errorf("error create index-[%s], status[%s]", idx, resp.Status())
+	}
+
 	return nil
 }
 
@@ -0,0 +1,31 @@
+package product
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestProductDoc(t *testing.T) {
+	data, err := json.Marshal(productDoc(&Product{Id: "1", SKU: "A-100", Title: "shoe", Brand: "newaim", Vector: []float32{1}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// an emptied field is sent as null so the update clears it, the vector is left alone.
+	for _, v := range []string{"category", "popularity"} {
+		if value, found := doc[v]; !found || value != nil {
+			t.Errorf("%s should be cleared: %v", v, doc)
+		}
+	}
+	if doc["brand"] != "newaim" || doc["sku"] != "A-100" {
+		t.Errorf("unexpected doc: %v", doc)
+	}
+	if _, found := doc["vector"]; found {
+		t.Errorf("the update should keep the stored vector: %v", doc)
+	}
+}
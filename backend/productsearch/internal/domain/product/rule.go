@@ -0,0 +1,353 @@
+package product
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	RuleMatchExact    = "exact"
+	RuleMatchContains = "contains"
+
+	RuleActionPin  = "pin"
+	RuleActionBury = "bury"
+
+	BranchRule = "rule"
+
+	ruleKey = "newaim_product_rule"
+)
+
+var ErrRuleNotFound = errors.New("rule not found")
+
+/*
+@desc: merchandising rule of a query, pinned sku go to the top of the first page,
+buried sku to the bottom of the page and a redirect sends the user away from the result.
+*/
+type Rule struct {
+	Id    string `json:"id"`
+	Query string `json:"query"`
+	// Match exact or contains, compared on the normalized keyword.
+	Match    string   `json:"match"`
+	Pin      []string `json:"pin,omitempty"`
+	Bury     []string `json:"bury,omitempty"`
+	Redirect string   `json:"redirect,omitempty"`
+	// Label shown by the frontend on the affected product, e.g. sponsored or featured.
+	Label      string    `json:"label,omitempty"`
+	Enabled    bool      `json:"enabled"`
+	CreateTime time.Time `json:"createTime"`
+	UpdateTime time.Time `json:"updateTime"`
+}
+
+/*
+@desc: mark of the rule on an affected product.
+*/
+type RuleMark struct {
+	Id     string `json:"id"`
+	Action string `json:"action"`
+	Label  string `json:"label,omitempty"`
+}
+
+type Redirect struct {
+	URL    string `json:"url"`
+	RuleId string `json:"ruleId"`
+}
+
+func normalizeRuleQuery(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+func (r *Rule) matches(keyword string) bool {
+	if !r.Enabled || keyword == "" {
+		return false
+	}
+
+	if r.Match == RuleMatchContains {
+		return strings.Contains(keyword, r.Query)
+	}
+	return keyword == r.Query
+}
+
+type ruleRepo struct {
+	rds *redis.Client
+}
+
+func (rr *ruleRepo) List(ctx context.Context) ([]Rule, error) {
+	data, err := rr.rds.HGetAll(ctx, ruleKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Rule, 0, len(data))
+	for _, v := range data {
+		var rule Rule
+		if err := json.Unmarshal([]byte(v), &rule); err != nil {
+			return nil, err
+		}
+		result = append(result, rule)
+	}
+
+	// the older rule applies first.
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreateTime.Equal(result[j].CreateTime) {
+			return result[i].Id < result[j].Id
+		}
+		return result[i].CreateTime.Before(result[j].CreateTime)
+	})
+
+	return result, nil
+}
+
+func (rr *ruleRepo) Get(ctx context.Context, id string) (*Rule, error) {
+	data, err := rr.rds.HGet(ctx, ruleKey, id).Result()
+	if err == redis.Nil {
+		return nil, ErrRuleNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var rule Rule
+	if err := json.Unmarshal([]byte(data), &rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (rr *ruleRepo) Save(ctx context.Context, rule *Rule) error {
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	return rr.rds.HSet(ctx, ruleKey, rule.Id, data).Err()
+}
+
+func (rr *ruleRepo) Delete(ctx context.Context, id string) error {
+	n, err := rr.rds.HDel(ctx, ruleKey, id).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+func (uc *UseCase) Rules(ctx context.Context) ([]Rule, error) {
+	return uc.rules.List(ctx)
+}
+
+func (uc *UseCase) CreateRule(ctx context.Context, rule *Rule) error {
+	rule.Id = NewIdGenerator().NewId()
+	rule.Query = normalizeRuleQuery(rule.Query)
+	rule.CreateTime = time.Now()
+	rule.UpdateTime = rule.CreateTime
+
+	return uc.rules.Save(ctx, rule)
+}
+
+func (uc *UseCase) UpdateRule(ctx context.Context, rule *Rule) error {
+	old, err := uc.rules.Get(ctx, rule.Id)
+	if err != nil {
+		return err
+	}
+
+	rule.Query = normalizeRuleQuery(rule.Query)
+	rule.CreateTime = old.CreateTime
+	rule.UpdateTime = time.Now()
+
+	return uc.rules.Save(ctx, rule)
+}
+
+func (uc *UseCase) DeleteRule(ctx context.Context, id string) error {
+	return uc.rules.Delete(ctx, id)
+}
+
+/*
+@desc: rules matching the keyword of a query. the pinned products lead the result, they are kept out of
+the search on every page and the offset of the search is shifted by them, so every page keeps its size and
+no product shows twice. bury is page local, a buried product goes to the bottom of the page it is retrieved on.
+*/
+type ruleMatch struct {
+	redirect *Redirect
+	// pinSku of every matched rule, pinned the pinned products found under the filter, in pin order.
+	pinSku   []string
+	pinMark  map[string]RuleMark
+	pinned   []Product
+	buryMark map[string]RuleMark
+}
+
+func newRuleMatch(rules []Rule) *ruleMatch {
+	m := &ruleMatch{
+		pinSku:   make([]string, 0),
+		pinMark:  make(map[string]RuleMark),
+		buryMark: make(map[string]RuleMark),
+	}
+
+	for _, v := range rules {
+		if v.Redirect != "" && m.redirect == nil {
+			m.redirect = &Redirect{URL: v.Redirect, RuleId: v.Id}
+		}
+
+		for _, sku := range v.Pin {
+			if _, found := m.pinMark[sku]; !found {
+				m.pinMark[sku] = RuleMark{Id: v.Id, Action: RuleActionPin, Label: v.Label}
+				m.pinSku = append(m.pinSku, sku)
+			}
+		}
+
+		for _, sku := range v.Bury {
+			if _, found := m.buryMark[sku]; !found {
+				m.buryMark[sku] = RuleMark{Id: v.Id, Action: RuleActionBury, Label: v.Label}
+			}
+		}
+	}
+
+	return m
+}
+
+/*
+@desc: the rules matching the keyword, with the pinned products looked up under the filter of the query.
+*/
+func (uc *UseCase) matchRules(ctx context.Context, req QueryRequest) (*ruleMatch, error) {
+	matched, err := uc.matchedRules(ctx, req.Keyword)
+	if err != nil {
+		return nil, err
+	}
+
+	m := newRuleMatch(matched)
+	if len(m.pinSku) > 0 {
+		found, err := uc.repo.SearchBySku(ctx, m.pinSku, req.Filter)
+		if err != nil {
+			return nil, err
+		}
+		m.pin(found)
+	}
+
+	return m, nil
+}
+
+func (uc *UseCase) matchedRules(ctx context.Context, keyword string) ([]Rule, error) {
+	keyword = normalizeRuleQuery(keyword)
+	if keyword == "" {
+		return nil, nil
+	}
+
+	rules, err := uc.rules.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]Rule, 0)
+	for _, v := range rules {
+		if v.matches(keyword) {
+			matched = append(matched, v)
+		}
+	}
+	return matched, nil
+}
+
+func (m *ruleMatch) pin(found []Product) {
+	bySku := make(map[string]Product, len(found))
+	for _, v := range found {
+		bySku[v.SKU] = v
+	}
+
+	m.pinned = make([]Product, 0, len(m.pinSku))
+	for _, sku := range m.pinSku {
+		if p, ok := bySku[sku]; ok {
+			mark := m.pinMark[sku]
+			p.Rule = &mark
+			m.pinned = append(m.pinned, p)
+		}
+	}
+}
+
+/*
+@desc: the pinned products shown on the page of the request. a later cursor page has none, the first one
+keeps a place for the search so the cursor moves on.
+*/
+func (m *ruleMatch) pinWindow(req QueryRequest) (int64, int64) {
+	n := int64(len(m.pinned))
+	from, size := req.From, req.Size
+	if req.Cursor != "" {
+		if req.Cursor != CursorStart {
+			return 0, 0
+		}
+		from, size = 0, size-1
+	}
+
+	start, end := from, from+size
+	if start > n {
+		start = n
+	}
+	if end > n {
+		end = n
+	}
+	if end < start {
+		end = start
+	}
+	return start, end
+}
+
+/*
+@desc: the search request of the page, without the pinned products and shifted by them.
+*/
+func (m *ruleMatch) request(req QueryRequest) QueryRequest {
+	if len(m.pinSku) == 0 {
+		return req
+	}
+
+	req.Filter.ExcludeSku = m.pinSku
+
+	start, end := m.pinWindow(req)
+	if n := int64(len(m.pinned)); req.Cursor == "" && req.From > n {
+		req.From -= n
+	} else {
+		req.From = 0
+	}
+	req.Size -= end - start
+	return req
+}
+
+/*
+@desc: apply the rules on the page retrieved by the shifted request.
+*/
+func (m *ruleMatch) apply(ctx context.Context, req QueryRequest, resp *QueryResponse) {
+	if m.redirect != nil {
+		resp.Redirect = m.redirect
+	}
+	if len(m.pinSku) == 0 && len(m.buryMark) == 0 {
+		return
+	}
+
+	start, end := m.pinWindow(req)
+	pinned := m.pinned[start:end]
+	explainFrom(ctx).branch(pinned, BranchRule)
+
+	kept := make([]Product, 0, len(resp.Data))
+	buried := make([]Product, 0)
+	for _, v := range resp.Data {
+		if mark, found := m.buryMark[v.SKU]; found {
+			v.Rule = &mark
+			buried = append(buried, v)
+		} else {
+			kept = append(kept, v)
+		}
+	}
+
+	result := make([]Product, 0, len(pinned)+len(kept)+len(buried))
+	result = append(result, pinned...)
+	result = append(result, kept...)
+	result = append(result, buried...)
+	if int64(len(result)) > req.Size {
+		result = result[:req.Size]
+	}
+
+	resp.Data = result
+	// the search no longer counts the pinned products.
+	resp.Total += int64(len(m.pinned))
+}
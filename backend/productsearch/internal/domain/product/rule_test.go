@@ -0,0 +1,106 @@
+package product
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"strings"
+	"testing"
+)
+
+func TestApplyRules(t *testing.T) {
+	mr := miniredis.RunT(t)
+	uc := &UseCase{rules: &ruleRepo{rds: redis.NewClient(&redis.Options{Addr: mr.Addr()})}}
+	ctx := context.Background()
+
+	rules := []*Rule{
+		{Query: " Running  Shoes", Pin: []string{"C", "X"}, Label: "featured", Enabled: true},
+		{Query: "shoes", Match: RuleMatchContains, Pin: []string{"P"}, Bury: []string{"R1"}, Redirect: "/sale", Enabled: true},
+		{Query: "running shoes", Redirect: "/disabled", Enabled: false},
+	}
+	for _, v := range rules {
+		if err := uc.CreateRule(ctx, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	matched, err := uc.matchedRules(ctx, "running shoes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newRuleMatch(matched)
+	// X is not found under the filter.
+	m.pin([]Product{{Id: "p", SKU: "P"}, {Id: "c", SKU: "C"}})
+
+	// the catalog the search pages through, pinned products included.
+	catalog := []Product{{Id: "c", SKU: "C"}}
+	for i := 0; i < 10; i++ {
+		catalog = append(catalog, Product{Id: fmt.Sprintf("r%d", i), SKU: fmt.Sprintf("R%d", i)})
+	}
+	search := func(req QueryRequest) QueryResponse {
+		excluded := strings.Join(req.Filter.ExcludeSku, ",")
+		hits := make([]Product, 0)
+		for _, v := range catalog {
+			if !strings.Contains(excluded, v.SKU) {
+				hits = append(hits, v)
+			}
+		}
+
+		resp := QueryResponse{Data: []Product{}, Total: int64(len(hits))}
+		for i := req.From; i < req.From+req.Size && i < int64(len(hits)); i++ {
+			resp.Data = append(resp.Data, hits[i])
+		}
+		return resp
+	}
+
+	pages := make([]string, 0)
+	for from := int64(0); from < 12; from += 3 {
+		req := QueryRequest{Keyword: "running shoes", From: from, Size: 3}
+		resp := search(m.request(req))
+		m.apply(ctx, req, &resp)
+
+		if len(resp.Data) != 3 || resp.Total != 12 {
+			t.Fatalf("page from %d should keep its size: %d of %d", from, len(resp.Data), resp.Total)
+		}
+		if resp.Redirect == nil || resp.Redirect.URL != "/sale" {
+			t.Errorf("unexpected redirect: %+v", resp.Redirect)
+		}
+
+		page := ""
+		for _, v := range resp.Data {
+			page += v.SKU
+		}
+		pages = append(pages, page)
+
+		if from == 0 {
+			if mark := resp.Data[0].Rule; mark == nil || mark.Action != RuleActionPin || mark.Id != rules[0].Id || mark.Label != "featured" {
+				t.Errorf("unexpected pin mark: %+v", mark)
+			}
+		}
+		if from == 3 {
+			if mark := resp.Data[2].Rule; mark == nil || mark.Action != RuleActionBury || mark.Id != rules[1].Id {
+				t.Errorf("unexpected bury mark: %+v", mark)
+			}
+		}
+	}
+
+	// pinned first, no product twice, bury stays on its page.
+	if order := strings.Join(pages, "|"); order != "CPR0|R2R3R1|R4R5R6|R7R8R9" {
+		t.Errorf("unexpected pages: %s", order)
+	}
+
+	// the first cursor page keeps a place for the search, later ones have no pin.
+	req := QueryRequest{Keyword: "running shoes", Size: 2, Cursor: CursorStart}
+	if shifted := m.request(req); shifted.Size != 1 {
+		t.Errorf("unexpected first cursor page size: %d", shifted.Size)
+	}
+	req.Cursor = "next"
+	if shifted := m.request(req); shifted.Size != 2 || len(shifted.Filter.ExcludeSku) != 3 {
+		t.Errorf("unexpected later cursor page: %+v", shifted)
+	}
+
+	if err := uc.DeleteRule(ctx, "missing"); err != ErrRuleNotFound {
+		t.Errorf("unexpected delete error: %v", err)
+	}
+}
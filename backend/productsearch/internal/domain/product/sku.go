@@ -0,0 +1,64 @@
+package product
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// a code has no space and either segments joined by - or _ with a digit in some segment, like V539-NIK-DD6337-661-L,
+// or letters mixed with a run of 3 digits, like DD6337. queries like "air max 90" or "2023" stay text.
+var defaultSkuPatterns = []string{
+	`^([A-Za-z0-9]+[-_])*[A-Za-z0-9]*[0-9][A-Za-z0-9]*([-_][A-Za-z0-9]+)+$`,
+	`^([A-Za-z0-9]+[-_])+[A-Za-z0-9]*[0-9][A-Za-z0-9]*$`,
+	`^[A-Za-z][A-Za-z0-9]*[0-9]{3}[A-Za-z0-9]*$`,
+	`^[0-9]{3,}[A-Za-z][A-Za-z0-9]*$`,
+}
+
+func compileSkuPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		patterns = defaultSkuPatterns
+	}
+
+	result := make([]*regexp.Regexp, 0, len(patterns))
+	for _, v := range patterns {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sku pattern-[%s]: %s", v, err.Error())
+		}
+		result = append(result, re)
+	}
+
+	return result, nil
+}
+
+/*
+@desc: the keyword is looked up as sku when it matches any of the configured patterns.
+*/
+func (uc *UseCase) isSku(keyword string) bool {
+	keyword = strings.TrimSpace(keyword)
+	if keyword == "" {
+		return false
+	}
+
+	for _, v := range uc.skuPatterns {
+		if v.MatchString(keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+@desc: same as the sku_normalizer of the index, case and separators are ignored.
+*/
+func NormalizeSku(sku string) string {
+	var b strings.Builder
+	for _, r := range sku {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
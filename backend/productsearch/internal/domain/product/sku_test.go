@@ -0,0 +1,50 @@
+package product
+
+import (
+	"testing"
+)
+
+func TestIsSku(t *testing.T) {
+	patterns, err := compileSkuPatterns(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uc := &UseCase{skuPatterns: patterns}
+
+	cases := map[string]bool{
+		"V539-NIK-DD6337-661-L": true,
+		"v539-nik-dd6337-661-l": true,
+		"V539_NIK_DD6337":       true,
+		"V539-NIK":              true,
+		"NIK-V539":              true,
+		"DD6337":                true,
+		"6337DD":                true,
+		"V539 NIK DD6337 661 L": false,
+		"NIKE":                  false,
+		"running shoes":         false,
+		"air max 90":            false,
+		"iphone 15 case":        false,
+		"size 10 running shoes": false,
+		"2023":                  false,
+		"iphone15":              false,
+		"t-shirt":               false,
+		"":                      false,
+	}
+	for keyword, want := range cases {
+		if got := uc.isSku(keyword); got != want {
+			t.Errorf("isSku(%q) = %v, want %v", keyword, got, want)
+		}
+	}
+
+	if _, err := compileSkuPatterns([]string{"("}); err == nil {
+		t.Error("invalid pattern should fail")
+	}
+}
+
+func TestNormalizeSku(t *testing.T) {
+	for _, v := range []string{"V539-NIK-DD6337", "v539 nik dd6337", "V539_NIK.DD6337"} {
+		if got := NormalizeSku(v); got != "v539nikdd6337" {
+			t.Errorf("NormalizeSku(%q) = %q", v, got)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package product
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SortRelevance  = "relevance"
+	SortCreateTime = "createTime"
+	SortUpdateTime = "updateTime"
+	SortSku        = "sku"
+)
+
+var ErrInvalidSort = errors.New("invalid sort")
+
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+/*
+@desc: parse sort keys like createTime:desc or relevance, earlier keys win and later keys break the tie.
+relevance is descending and the others ascending when the direction is omitted.
+*/
+func ParseSort(input []string) ([]SortField, error) {
+	result := make([]SortField, 0, len(input))
+	seen := make(map[string]bool)
+
+	for _, item := range input {
+		for _, v := range strings.Split(item, ",") {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+
+			field, direction := v, ""
+			if i := strings.IndexRune(v, ':'); i >= 0 {
+				field, direction = v[:i], strings.ToLower(v[i+1:])
+			}
+
+			switch field {
+			case SortRelevance, SortCreateTime, SortUpdateTime, SortSku:
+			default:
+				return nil, fmt.Errorf("%w: unknown field-[%s]", ErrInvalidSort, field)
+			}
+
+			if seen[field] {
+				return nil, fmt.Errorf("%w: duplicated field-[%s]", ErrInvalidSort, field)
+			}
+			seen[field] = true
+
+			sf := SortField{Field: field}
+			switch direction {
+			case "":
+				sf.Desc = field == SortRelevance
+			case "asc":
+			case "desc":
+				sf.Desc = true
+			default:
+				return nil, fmt.Errorf("%w: unknown direction-[%s]", ErrInvalidSort, direction)
+			}
+
+			result = append(result, sf)
+		}
+	}
+
+	return result, nil
+}
+
+/*
+@desc: relevance first or no sort at all, the ranking of the search is kept.
+*/
+func byRelevance(s []SortField) bool {
+	return len(s) == 0 || (s[0].Field == SortRelevance && s[0].Desc)
+}
+
+/*
+@desc: es sort clause, the score always breaks the remaining tie.
+*/
+func sortClauses(s []SortField) []interface{} {
+	result := make([]interface{}, 0, len(s)+1)
+
+	hasScore := false
+	for _, v := range s {
+		order := "asc"
+		if v.Desc {
+			order = "desc"
+		}
+
+		field := v.Field
+		if field == SortRelevance {
+			field = "_score"
+			hasScore = true
+		}
+
+		result = append(result, map[string]interface{}{
+			field: order,
+		})
+	}
+
+	if !hasScore {
+		result = append(result, map[string]interface{}{
+			"_score": "desc",
+		})
+	}
+
+	return result
+}
+
+/*
+@desc: sort products merged outside of es, the incoming order is their relevance.
+*/
+func sortProducts(products []Product, s []SortField) {
+	if byRelevance(s) {
+		return
+	}
+
+	rank := make(map[string]int, len(products))
+	for i, v := range products {
+		rank[v.Id] = i
+	}
+
+	sort.SliceStable(products, func(i, j int) bool {
+		a, b := products[i], products[j]
+		for _, v := range s {
+			var c int
+			switch v.Field {
+			case SortCreateTime:
+				c = compareTime(a.CreateTime, b.CreateTime)
+			case SortUpdateTime:
+				c = compareTime(a.UpdateTime, b.UpdateTime)
+			case SortSku:
+				c = strings.Compare(a.SKU, b.SKU)
+			case SortRelevance:
+				// a better rank is a lower index, descending relevance is ascending rank.
+				c = rank[b.Id] - rank[a.Id]
+			}
+
+			if c == 0 {
+				continue
+			}
+			if v.Desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+func compareTime(a, b time.Time) int {
+	if a.Before(b) {
+		return -1
+	}
+	if a.After(b) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,59 @@
+package product
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseSort(t *testing.T) {
+	s, err := ParseSort([]string{"createTime:desc,sku", "relevance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []SortField{{SortCreateTime, true}, {SortSku, false}, {SortRelevance, true}}
+	if len(s) != len(want) {
+		t.Fatalf("unexpected sort: %v", s)
+	}
+	for i := range want {
+		if s[i] != want[i] {
+			t.Errorf("sort[%d] = %v, want %v", i, s[i], want[i])
+		}
+	}
+
+	for _, v := range []string{"title", "sku:up", "sku,sku:desc"} {
+		if _, err := ParseSort([]string{v}); !errors.Is(err, ErrInvalidSort) {
+			t.Errorf("ParseSort(%q) should fail, got %v", v, err)
+		}
+	}
+
+	data, _ := json.Marshal(sortClauses(s[:1]))
+	if string(data) != `[{"createTime":"desc"},{"_score":"desc"}]` {
+		t.Errorf("unexpected sort clause: %s", data)
+	}
+}
+
+func TestSortProducts(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	// relevance order a, b, c.
+	products := []Product{
+		{Id: "a", SKU: "B", CreateTime: day(1)},
+		{Id: "b", SKU: "A", CreateTime: day(2)},
+		{Id: "c", SKU: "C", CreateTime: day(2)},
+	}
+
+	sortProducts(products, []SortField{{SortCreateTime, true}, {SortRelevance, true}})
+	if products[0].Id != "b" || products[1].Id != "c" || products[2].Id != "a" {
+		t.Errorf("unexpected order: %v %v %v", products[0].Id, products[1].Id, products[2].Id)
+	}
+
+	sortProducts(products, []SortField{{SortSku, false}})
+	if products[0].Id != "b" || products[1].Id != "a" || products[2].Id != "c" {
+		t.Errorf("unexpected order: %v %v %v", products[0].Id, products[1].Id, products[2].Id)
+	}
+}
@@ -0,0 +1,102 @@
+package product
+
+import (
+	"context"
+)
+
+// fields proposing a correction, the best scored option of all of them wins.
+var spellingFields = []string{"title", "description"}
+
+type ESSuggestEntry struct {
+	Text    string            `json:"text"`
+	Options []ESSuggestOption `json:"options"`
+}
+
+type ESSuggestOption struct {
+	Text  string  `json:"text"`
+	Score float64 `json:"score"`
+}
+
+/*
+@desc: phrase suggester over title and description, an empty result means no better spelling is known.
+*/
+func (r *repo) SuggestQuery(ctx context.Context, keyword string) (string, error) {
+	data, err := r.searchFromES(ctx, productIndex, 0, 0, map[string]interface{}{
+		"suggest": spellingSuggest(keyword),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return bestSpelling(keyword, data.Suggest), nil
+}
+
+/*
+@desc: a phrase suggester by spelling field, the collate query drops the suggestion which would still hit nothing.
+*/
+func spellingSuggest(keyword string) map[string]interface{} {
+	suggest := map[string]interface{}{
+		"text": keyword,
+	}
+	for _, v := range spellingFields {
+		suggest[v] = map[string]interface{}{
+			"phrase": map[string]interface{}{
+				"field":      v,
+				"size":       1,
+				"max_errors": 2,
+				"direct_generator": []interface{}{
+					map[string]interface{}{
+						"field":        v,
+						"suggest_mode": "always",
+					},
+				},
+				"collate": map[string]interface{}{
+					"query": map[string]interface{}{
+						"source": map[string]interface{}{
+							"match": map[string]interface{}{
+								v: map[string]interface{}{
+									"query":    "{{suggestion}}",
+									"operator": "and",
+								},
+							},
+						},
+					},
+					"prune": false,
+				},
+			},
+		}
+	}
+	return suggest
+}
+
+func bestSpelling(keyword string, suggest map[string][]ESSuggestEntry) string {
+	var (
+		best  string
+		score float64
+	)
+	for _, v := range spellingFields {
+		for _, entry := range suggest[v] {
+			for _, option := range entry.Options {
+				if option.Score > score && option.Text != keyword {
+					best, score = option.Text, option.Score
+				}
+			}
+		}
+	}
+	return best
+}
+
+/*
+@desc: only a zero result keyword gets a spelling pass, a keyword using the query language is taken as meant.
+*/
+func needSpelling(keyword string, total int64) bool {
+	node := parseQuery(keyword)
+	return total == 0 && node != nil && node.plain()
+}
+
+/*
+@desc: whether the query is re-run with the suggested spelling, withDefault fills the request from the config.
+*/
+func autoCorrect(req QueryRequest, suggested string) bool {
+	return suggested != "" && req.AutoCorrect != nil && *req.AutoCorrect
+}
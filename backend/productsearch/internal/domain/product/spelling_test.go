@@ -0,0 +1,101 @@
+package product
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSpellingSuggest(t *testing.T) {
+	body := spellingSuggest("runnig shoes")
+	if body["text"] != "runnig shoes" {
+		t.Errorf("unexpected suggest text: %v", body["text"])
+	}
+	delete(body, "text")
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var suggest map[string]struct {
+		Phrase struct {
+			Field           string `json:"field"`
+			Size            int    `json:"size"`
+			DirectGenerator []struct {
+				Field string `json:"field"`
+			} `json:"direct_generator"`
+			Collate struct {
+				Query struct {
+					Source map[string]map[string]struct {
+						Query    string `json:"query"`
+						Operator string `json:"operator"`
+					} `json:"source"`
+				} `json:"query"`
+				Prune bool `json:"prune"`
+			} `json:"collate"`
+		} `json:"phrase"`
+	}
+	if err := json.Unmarshal(data, &suggest); err != nil || len(suggest) != len(spellingFields) {
+		t.Fatalf("unexpected suggest body: %s %v", data, err)
+	}
+
+	for _, v := range spellingFields {
+		p := suggest[v].Phrase
+		if p.Field != v || p.Size != 1 || len(p.DirectGenerator) != 1 || p.DirectGenerator[0].Field != v {
+			t.Errorf("unexpected phrase suggester of %s: %+v", v, p)
+		}
+		// the collate keeps only the suggestions hitting a product.
+		if m := p.Collate.Query.Source["match"][v]; m.Query != "{{suggestion}}" || m.Operator != "and" || p.Collate.Prune {
+			t.Errorf("unexpected collate of %s: %+v", v, p.Collate)
+		}
+	}
+}
+
+func TestBestSpelling(t *testing.T) {
+	suggest := map[string][]ESSuggestEntry{
+		"title": {{Text: "runnig shoes", Options: []ESSuggestOption{{Text: "running shoes", Score: 0.3}}}},
+		"description": {{Text: "runnig shoes", Options: []ESSuggestOption{
+			{Text: "runnig shoes", Score: 0.9},
+			{Text: "running shoe", Score: 0.5},
+		}}},
+	}
+	if best := bestSpelling("runnig shoes", suggest); best != "running shoe" {
+		t.Errorf("the best scored option of all fields should win: %s", best)
+	}
+	if best := bestSpelling("runnig shoes", nil); best != "" {
+		t.Errorf("no option should suggest nothing: %s", best)
+	}
+}
+
+func TestAutoCorrectDecision(t *testing.T) {
+	on, off := true, false
+	cases := []struct {
+		keyword   string
+		total     int64
+		suggested string
+		auto      *bool
+		spelling  bool
+		corrected bool
+	}{
+		{"runnig shoes", 0, "running shoes", &on, true, true},
+		{"runnig shoes", 0, "running shoes", &off, true, false},
+		{"runnig shoes", 0, "running shoes", nil, true, false},
+		{"runnig shoes", 0, "", &on, true, false},
+		// a hit keyword is not corrected.
+		{"runnig shoes", 3, "running shoes", &on, false, true},
+		// the query language is taken as meant.
+		{"runnig OR shoes", 0, "running shoes", &on, false, true},
+		{"runnig -boots", 0, "running boots", &on, false, true},
+		{"\"runnig shoes\"", 0, "running shoes", &on, false, true},
+		{"", 0, "", &on, false, false},
+	}
+
+	for _, v := range cases {
+		if spelling := needSpelling(v.keyword, v.total); spelling != v.spelling {
+			t.Errorf("needSpelling(%q, %d) = %v", v.keyword, v.total, spelling)
+		}
+		if corrected := autoCorrect(QueryRequest{Keyword: v.keyword, AutoCorrect: v.auto}, v.suggested); corrected != v.corrected {
+			t.Errorf("autoCorrect(%q, %q) = %v", v.keyword, v.suggested, corrected)
+		}
+	}
+}
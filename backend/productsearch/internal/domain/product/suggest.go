@@ -0,0 +1,107 @@
+package product
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSuggestSize    = 5
+	defaultSuggestMaxSize = 10
+	defaultSuggestTimeout = 200 * time.Millisecond
+)
+
+// search_as_you_type subfields and their shingles.
+var suggestFields = []string{
+	"title.sayt",
+	"title.sayt._2gram",
+	"title.sayt._3gram",
+	"sku.sayt",
+	"sku.sayt._2gram",
+	"sku.sayt._3gram",
+}
+
+type Suggestion struct {
+	Id    string `json:"id"`
+	SKU   string `json:"sku"`
+	Title string `json:"title"`
+}
+
+/*
+@desc: complete the typed prefix against title and sku, only the fields needed by the dropdown are fetched.
+*/
+func (r *repo) Suggest(ctx context.Context, prefix string, size int64, timeout time.Duration) ([]Suggestion, error) {
+	query := map[string]interface{}{
+		"_source": false,
+		"fields":  []string{"sku", "title"},
+		"timeout": fmt.Sprintf("%dms", timeout.Milliseconds()),
+		"query": map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":  prefix,
+				"type":   "bool_prefix",
+				"fields": suggestFields,
+			},
+		},
+	}
+
+	data, err := r.searchFromES(ctx, productIndex, 0, size, query)
+	if err != nil {
+		return nil, err
+	}
+
+	first := func(input interface{}) string {
+		if val, ok := input.([]interface{}); ok && len(val) > 0 {
+			if sVal, ok := val[0].(string); ok {
+				return sVal
+			}
+		}
+		return ""
+	}
+
+	result := make([]Suggestion, 0, len(data.Hits.Hits))
+	for _, v := range data.Hits.Hits {
+		result = append(result, Suggestion{
+			Id:    v.Id,
+			SKU:   first(v.Fields["sku"]),
+			Title: first(v.Fields["title"]),
+		})
+	}
+
+	return result, nil
+}
+
+/*
+@desc: autocomplete for the search box, it runs on every key stroke so the latency budget is tight.
+*/
+func (uc *UseCase) Suggest(ctx context.Context, prefix string, size int64) ([]Suggestion, error) {
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" {
+		return []Suggestion{}, nil
+	}
+
+	c := uc.ctx.Config.Search.Suggest
+
+	maxSize := int64(c.MaxSize)
+	if maxSize <= 0 {
+		maxSize = defaultSuggestMaxSize
+	}
+	if size <= 0 {
+		size = defaultSuggestSize
+	}
+	if size > maxSize {
+		size = maxSize
+	}
+
+	timeout := time.Duration(c.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultSuggestTimeout
+	}
+
+	// es timeout only bounds the shard search, the context bounds the whole round trip.
+	ctx, cancel := context.WithTimeout(ctx, 2*timeout)
+	defer cancel()
+
+	return uc.repo.Suggest(ctx, prefix, size, timeout)
+}
@@ -2,33 +2,53 @@ package product
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/ringbrew/newaim/productsearch/internal/domain"
 	"github.com/ringbrew/newaim/productsearch/internal/domain/embedding"
 	"log"
+	"regexp"
 	"strings"
 	"time"
-	"unicode"
 )
 
+// products by page when the memory store is loaded from es.
+const loadPageSize = 500
+
 type UseCase struct {
 	ctx  *domain.UseCaseContext
 	repo *repo
-	ms   *MilvusStore
+	vs   VectorStore
+	em   embedding.Embedding
+
+	skuPatterns []*regexp.Regexp
+	rules       *ruleRepo
 }
 
 func NewUseCase(ctx *domain.UseCaseContext) *UseCase {
 	uc := &UseCase{
-		ctx:  ctx,
-		repo: newRepo(ctx),
+		ctx:   ctx,
+		repo:  newRepo(ctx),
+		rules: &ruleRepo{rds: ctx.Redis},
 	}
 
-	if ctx.Config.Miluvs.Endpoint != "" {
-		ms, err := newMilvusStore(ctx)
-		if err != nil {
-			log.Fatal(err.Error())
-		}
-		uc.ms = ms
+	vs, err := newVectorStore(ctx, uc.repo)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	uc.vs = vs
+
+	em, err := embedding.NewEmbedding(ctx)
+	if err != nil && !errors.Is(err, embedding.ErrNotConfigured) {
+		log.Fatal(err.Error())
 	}
+	uc.em = em
+
+	skuPatterns, err := compileSkuPatterns(ctx.Config.Search.SkuPatterns)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	uc.skuPatterns = skuPatterns
 
 	return uc
 }
@@ -37,51 +57,257 @@ func (uc *UseCase) Count(ctx context.Context) (int64, error) {
 	return uc.repo.CountIndex(productIndex)
 }
 
-func (uc *UseCase) Rebuild(ctx context.Context) error {
-	if err := uc.repo.DeleteIndexES(productIndex); err != nil {
+/*
+@desc: load product into a new index version, validate it, then swap the productIndex alias to it.
+the search keeps using the old version until the swap, which stays available for Rollback.
+a *BatchCreateError is returned after the swap when some product fail to embed.
+*/
+func (uc *UseCase) Rebuild(ctx context.Context, product []*Product) error {
+	index := newVersionIndex()
+	if err := uc.repo.CreateIndexES(index, uc.repo.mapping); err != nil {
 		return err
 	}
-	if err := uc.repo.CreateIndexES(productIndex, productMapping); err != nil {
+
+	if err := uc.repo.BulkIndex(index); err != nil {
 		return err
 	}
-	return nil
+
+	vs := uc.vs
+	if ibs, ok := vs.(indexBoundStore); ok {
+		vs = ibs.withIndex(index)
+	}
+
+	bcErr := uc.batchCreate(ctx, index, vs, product)
+
+	var bce *BatchCreateError
+	if bcErr != nil && !errors.As(bcErr, &bce) {
+		uc.repo.CloseBulkIndex(ctx, index)
+		uc.repo.DeleteIndexES(index)
+		return bcErr
+	}
+
+	if err := uc.repo.CloseBulkIndex(ctx, index); err != nil {
+		return err
+	}
+
+	if err := uc.repo.RefreshIndex(ctx, index); err != nil {
+		return err
+	}
+
+	expect := make(map[string]struct{}, len(product))
+	for _, v := range product {
+		expect[v.Id] = struct{}{}
+	}
+
+	count, err := uc.repo.CountIndex(index)
+	if err != nil {
+		return err
+	}
+
+	if count != int64(len(expect)) {
+		uc.repo.DeleteIndexES(index)
+		return fmt.Errorf("rebuild index-[%s] count mismatch, want %d got %d", index, len(expect), count)
+	}
+
+	if err := uc.repo.SwapAlias(ctx, index); err != nil {
+		return err
+	}
+
+	retain := uc.ctx.Config.ElasticSearch.RetainVersions
+	if retain <= 0 {
+		retain = defaultRetainVersions
+	}
+
+	if err := uc.repo.PruneVersion(ctx, retain); err != nil {
+		log.Printf("prune index version fail: %s", err.Error())
+	}
+
+	return bcErr
+}
+
+/*
+@desc: swap the productIndex alias back to the newest version older than the active one.
+*/
+func (uc *UseCase) Rollback(ctx context.Context) (IndexVersion, error) {
+	versions, err := uc.repo.ListVersion(ctx)
+	if err != nil {
+		return IndexVersion{}, err
+	}
+
+	for i, v := range versions {
+		if v.Active && i+1 < len(versions) {
+			target := versions[i+1]
+			if err := uc.repo.SwapAlias(ctx, target.Name); err != nil {
+				return IndexVersion{}, err
+			}
+			target.Active = true
+			return target, nil
+		}
+	}
+
+	return IndexVersion{}, ErrNoRollbackVersion
+}
+
+func (uc *UseCase) Versions(ctx context.Context) ([]IndexVersion, error) {
+	versions, err := uc.repo.ListVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range versions {
+		if versions[i].Count, err = uc.repo.CountIndex(versions[i].Name); err != nil {
+			return nil, err
+		}
+	}
+
+	return versions, nil
 }
 
 func (uc *UseCase) BatchCreate(ctx context.Context, product []*Product) error {
-	emDoc := make([]string, len(product))
+	return uc.batchCreate(ctx, productIndex, uc.vs, product)
+}
 
-	for i, v := range product {
-		v.SetId(NewIdGenerator().NewId())
+func (uc *UseCase) batchCreate(ctx context.Context, index string, vs VectorStore, product []*Product) error {
+	for _, v := range product {
+		// the id follows the sku, so each rebuild keeps the id of the vector already stored.
+		v.SetId(skuId(v.SKU))
 		v.CreateTime = time.Now()
 		v.UpdateTime = time.Now()
-		emDoc[i] = v.Description
 	}
 
-	if err := uc.repo.CreateMany(ctx, product); err != nil {
+	if err := uc.repo.CreateMany(ctx, index, product); err != nil {
 		return err
 	}
 
-	if uc.ctx.Config.OpenAI.Token != "" {
-		em, err := embedding.NewEmbedding(uc.ctx, "AdaEmbeddingV2")
-		if err != nil {
-			return err
+	return uc.embedMany(ctx, vs, product)
+}
+
+/*
+@desc: embed the description of the products in batches and store the vectors,
+a BatchCreateError lists the products left without vector.
+*/
+func (uc *UseCase) embedMany(ctx context.Context, vs VectorStore, product []*Product) error {
+	emDoc := make([]string, len(product))
+	for i, v := range product {
+		emDoc[i] = v.Description
+	}
+
+	if uc.em != nil && vs != nil {
+		batcher := embedding.NewBatcher(uc.em, uc.ctx.Config.Embedding.Batch).WithProgress(func(p embedding.BatchProgress) {
+			log.Printf("embedding progress: batch %d/%d, failed %d, document %d/%d", p.Done+p.Failed, p.Batches, p.Failed, p.Embedded, p.Documents)
+		})
+
+		result := batcher.Embed(ctx, emDoc, func(ctx context.Context, batch embedding.Batch) error {
+			ds := make([]*Product, 0, len(batch.Index))
+			for _, v := range batch.Data {
+				// the index comes from the provider, a misbehaving one must not panic the rebuild.
+				if v.Index < 0 || v.Index >= len(batch.Index) {
+					return fmt.Errorf("invalid embedding response index %d", v.Index)
+				}
+				p := product[batch.Index[v.Index]]
+				p.Vector = v.Vector
+				ds = append(ds, p)
+			}
+
+			return vs.BatchCreate(ctx, ds)
+		})
+
+		if len(result.Failures) > 0 {
+			bce := &BatchCreateError{}
+			for _, f := range result.Failures {
+				for _, i := range f.Index {
+					bce.Failed = append(bce.Failed, BatchCreateFailure{
+						Id:  product[i].Id,
+						SKU: product[i].SKU,
+						Err: f.Err,
+					})
+				}
+			}
+			return bce
 		}
+	}
 
-		embeddingResult, err := em.EmbedDocument(ctx, embedding.DocumentRequest{Documents: emDoc})
+	return nil
+}
+
+/*
+@desc: the memory store starts empty on every start, fill it with the vectors of the products already in es.
+the other stores keep their vectors across a restart.
+*/
+func (uc *UseCase) LoadMemoryStore(ctx context.Context) error {
+	if _, ok := uc.vs.(*MemoryStore); !ok || uc.em == nil {
+		return nil
+	}
+
+	bce := &BatchCreateError{}
+	req := QueryRequest{Size: loadPageSize, Cursor: CursorStart}
+	for req.Cursor != "" {
+		page, err := uc.cursorQuery(ctx, req)
 		if err != nil {
 			return err
 		}
 
-		er := make(map[int]embedding.Vector)
-		for _, v := range embeddingResult.Data {
-			er[v.Index] = v.Vector
+		product := make([]*Product, len(page.Data))
+		for i := range page.Data {
+			product[i] = &page.Data[i]
+		}
+
+		if len(product) > 0 {
+			err := uc.embedMany(ctx, uc.vs, product)
+			var pageErr *BatchCreateError
+			if errors.As(err, &pageErr) {
+				bce.Failed = append(bce.Failed, pageErr.Failed...)
+			} else if err != nil {
+				return err
+			}
 		}
 
-		for i := range product {
-			product[i].Vector = er[i]
+		req.Cursor = page.NextCursor
+	}
+
+	if len(bce.Failed) > 0 {
+		return bce
+	}
+	return nil
+}
+
+/*
+@desc: BatchCreateError reports the products stored in es but without vector,
+they are still found by the lexical search.
+*/
+type BatchCreateError struct {
+	Failed []BatchCreateFailure
+}
+
+type BatchCreateFailure struct {
+	Id  string
+	SKU string
+	Err error
+}
+
+func (e *BatchCreateError) Error() string {
+	if len(e.Failed) == 0 {
+		return "batch create fail"
+	}
+	return fmt.Sprintf("%d product(s) fail to embed, sku-[%s]: %s", len(e.Failed), e.Failed[0].SKU, e.Failed[0].Err.Error())
+}
+
+func (uc *UseCase) Create(ctx context.Context, p *Product) error {
+	// the same id as a rebuild gives the sku, a sku is created once.
+	p.SetId(skuId(p.SKU))
+	p.CreateTime = time.Now()
+	p.UpdateTime = time.Now()
+
+	if err := uc.repo.Save(ctx, p); err != nil {
+		return err
+	}
+
+	if uc.vectorEnabled() {
+		if err := uc.embed(ctx, p); err != nil {
+			return err
 		}
 
-		if err := uc.ms.BatchCreate(ctx, product); err != nil {
+		if err := uc.vs.Upsert(ctx, p); err != nil {
 			return err
 		}
 	}
@@ -89,53 +315,301 @@ func (uc *UseCase) BatchCreate(ctx context.Context, product []*Product) error {
 	return nil
 }
 
-func (uc *UseCase) Query(ctx context.Context, keyword string, from, size int64) ([]Product, int64, error) {
-	isSku := func(s string) bool {
-		for _, r := range s {
-			if !unicode.IsUpper(r) && unicode.IsLetter(r) && r != '-' {
-				return false
-			}
+func (uc *UseCase) Get(ctx context.Context, id string) (Product, error) {
+	return uc.repo.Get(ctx, id)
+}
+
+func (uc *UseCase) Update(ctx context.Context, p *Product) error {
+	old, err := uc.repo.Get(ctx, p.Id)
+	if err != nil {
+		return err
+	}
+
+	p.CreateTime = old.CreateTime
+	p.UpdateTime = time.Now()
+
+	if err := uc.repo.Update(ctx, p); err != nil {
+		return err
+	}
+
+	// only a changed description needs a new vector.
+	if uc.vectorEnabled() && p.Description != old.Description {
+		if err := uc.embed(ctx, p); err != nil {
+			return err
+		}
+
+		// upsert replaces the vector in place, the product is never left without one.
+		if err := uc.vs.Upsert(ctx, p); err != nil {
+			return err
 		}
-		return true
 	}
 
-	result, total, err := uc.repo.Search(ctx, strings.Join(strings.Fields(keyword), " AND "), from, size, isSku(keyword))
+	return nil
+}
+
+func (uc *UseCase) Delete(ctx context.Context, id string) error {
+	old, err := uc.repo.Get(ctx, id)
 	if err != nil {
-		return nil, 0, err
+		return err
 	}
 
-	if total == 0 && uc.ctx.Config.OpenAI.Token != "" {
-		em, err := embedding.NewEmbedding(uc.ctx, "AdaEmbeddingV2")
-		if err != nil {
-			return nil, 0, err
+	if err := uc.repo.Delete(ctx, old.Id); err != nil {
+		return err
+	}
+
+	if uc.vs != nil {
+		if err := uc.vs.Delete(ctx, old); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
+
+/*
+@desc: hit and miss of the embedding cache, nil when the cache is not enabled.
+*/
+func (uc *UseCase) EmbeddingCacheStats() *embedding.CacheStats {
+	c, ok := uc.em.(*embedding.Cache)
+	if !ok {
+		return nil
+	}
+
+	stats := c.Stats()
+	return &stats
+}
+
+func (uc *UseCase) vectorEnabled() bool {
+	return uc.em != nil && uc.vs != nil
+}
 
-		qv, err := em.EmbedSingle(ctx, embedding.SingleRequest{Content: keyword})
+func (uc *UseCase) embed(ctx context.Context, p *Product) error {
+	pv, err := uc.em.EmbedSingle(ctx, embedding.SingleRequest{Content: p.Description})
+	if err != nil {
+		return err
+	}
+
+	p.Vector = pv.Data.Vector
+	return nil
+}
+
+func (uc *UseCase) Query(ctx context.Context, req QueryRequest) (QueryResponse, error) {
+	req = uc.withDefault(req)
+
+	// a failed rule lookup leaves the result untouched, merchandising must not break the search.
+	rules, err := uc.matchRules(ctx, req)
+	if err != nil {
+		log.Printf("match rule for keyword-[%s] fail: %s", req.Keyword, err.Error())
+		rules = newRuleMatch(nil)
+	}
+
+	resp, err := uc.query(ctx, rules.request(req))
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	rules.apply(ctx, req, &resp)
+	return resp, nil
+}
+
+func (uc *UseCase) query(ctx context.Context, req QueryRequest) (QueryResponse, error) {
+
+	if req.Cursor != "" {
+		return uc.cursorQuery(ctx, req)
+	}
+
+	if req.Mode == SearchModeHybrid && req.Keyword != "" && uc.vectorEnabled() {
+		return uc.hybridQuery(ctx, req)
+	}
+
+	lexical, err := uc.lexicalQuery(ctx, req, req.From, req.Size)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	resp := QueryResponse{}
+	if needSpelling(req.Keyword, lexical.Total) {
+		// a failed spelling pass must not fail the search itself.
+		suggested, err := uc.repo.SuggestQuery(ctx, req.Keyword)
 		if err != nil {
-			return nil, 0, err
+			log.Printf("suggest query for keyword-[%s] fail: %s", req.Keyword, err.Error())
 		}
+		resp.SuggestedQuery = suggested
 
-		qvr := QueryVectorRequest{}
-		qvr.Input = qv.Data.Vector
-		qvr.Top = int(size)
+		if autoCorrect(req, suggested) {
+			corrected := req
+			corrected.Keyword = suggested
+
+			lexical, err = uc.lexicalQuery(ctx, corrected, req.From, req.Size)
+			if err != nil {
+				return QueryResponse{}, err
+			}
+			resp.AutoCorrected = lexical.Total > 0
+		}
+	}
 
-		vr, err := uc.ms.Query(ctx, qvr)
+	result, total := lexical.Data, lexical.Total
+	if total == 0 && req.Keyword != "" && uc.vectorEnabled() {
+		page, err := uc.vectorPage(ctx, req)
 		if err != nil {
-			return nil, 0, err
+			return QueryResponse{}, err
 		}
+		explainFrom(ctx).branch(page.Data, BranchVector)
+
+		result, total = page.Data, page.Total
+		resp.Estimated = page.Estimated
+	}
+
+	resp.Data = result
+	resp.Total = total
+	resp.Facets = lexical.Facets
+	return resp, nil
+}
+
+func (uc *UseCase) lexicalQuery(ctx context.Context, req QueryRequest, from, size int64) (SearchResult, error) {
+	keyword := strings.TrimSpace(req.Keyword)
+	isSku := uc.isSku(keyword)
+
+	opt := SearchOption{
+		From:      from,
+		Size:      size,
+		IsSku:     isSku,
+		Filter:    req.Filter,
+		Facets:    req.Facets,
+		FacetSize: req.FacetSize,
+		Cursor:    req.cursor,
+		Sort:      req.Sort,
+	}
+	if req.Highlight {
+		opt.Highlight = newHighlightOption(uc.ctx.Config.Search.Highlight)
+	}
+	opt.Boost = newBoostOption(uc.ctx.Config.Search.Boost)
+
+	result, err := uc.repo.Search(ctx, keyword, opt)
+	if err != nil {
+		return SearchResult{}, err
+	}
+
+	branch := BranchText
+	if isSku {
+		branch = BranchSku
+	}
+	explainFrom(ctx).branch(result.Data, branch)
+
+	return result, nil
+}
+
+func (uc *UseCase) vectorQuery(ctx context.Context, req QueryRequest, top int) (QueryVectorResponse, error) {
+	qv, err := uc.em.EmbedSingle(ctx, embedding.SingleRequest{Content: req.Keyword})
+	if err != nil {
+		return QueryVectorResponse{}, err
+	}
+
+	qvr := QueryVectorRequest{}
+	qvr.Input = qv.Data.Vector
+	qvr.Top = top
+	qvr.Filter = req.Filter
+
+	vr, err := uc.vs.Query(ctx, qvr)
+	if err != nil {
+		return QueryVectorResponse{}, err
+	}
+
+	explainFrom(ctx).vector(vr)
+	return vr, nil
+}
+
+/*
+@desc: page of the vector result. the store has no offset in common, so the window up to the page end
+is fetched and sliced. the total counts the window only, it is estimated when the window is full.
+*/
+func (uc *UseCase) vectorPage(ctx context.Context, req QueryRequest) (QueryResponse, error) {
+	window := uc.vectorWindow(req)
+	if req.From >= window {
+		return QueryResponse{Data: []Product{}}, nil
+	}
 
-		idList := make([]string, 0)
-		for _, v := range vr.Data {
-			idList = append(idList, v.Id)
+	vr, err := uc.vectorQuery(ctx, req, int(window))
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	hydrated, err := uc.hydrate(ctx, vr, req.Filter)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+	sortProducts(hydrated, req.Sort)
+
+	result := QueryResponse{
+		Data:      []Product{},
+		Total:     int64(len(hydrated)),
+		Estimated: int64(len(vr.Data)) >= window,
+	}
+	if req.From < int64(len(hydrated)) {
+		end := req.From + req.Size
+		if end > int64(len(hydrated)) {
+			end = int64(len(hydrated))
 		}
+		result.Data = hydrated[req.From:end]
+	}
 
-		result, err = uc.repo.SearchById(ctx, idList)
-		if err != nil {
-			return nil, 0, err
+	return result, nil
+}
+
+/*
+@desc: the result window up to the end of the page, capped by the vector max window.
+*/
+func (uc *UseCase) vectorWindow(req QueryRequest) int64 {
+	maxWindow := int64(uc.ctx.Config.Search.VectorMaxWindow)
+	if maxWindow <= 0 {
+		maxWindow = defaultVectorMaxWindow
+	}
+
+	window := req.From + req.Size
+	if window > maxWindow {
+		window = maxWindow
+	}
+	return window
+}
+
+/*
+@desc: products of the vector result in the ranking order of the vector store,
+vectors whose product is gone or filtered out are skipped.
+*/
+func (uc *UseCase) hydrate(ctx context.Context, vr QueryVectorResponse, filter Filter) ([]Product, error) {
+	idList := make([]string, 0)
+	for _, v := range vr.Data {
+		idList = append(idList, v.Id)
+	}
+
+	// stores that keep the product next to its vector have already hydrated the result.
+	hydrated := make([]Product, 0, len(vr.Data))
+	for _, v := range vr.Data {
+		if v.Product != nil {
+			hydrated = append(hydrated, *v.Product)
 		}
+	}
+
+	if len(hydrated) == len(vr.Data) {
+		return hydrated, nil
+	}
 
-		total = int64(len(result))
+	data, err := uc.repo.SearchById(ctx, idList, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	products := make(map[string]Product, len(data))
+	for _, v := range data {
+		products[v.Id] = v
+	}
+
+	result := make([]Product, 0, len(data))
+	for _, v := range vr.Data {
+		if p, found := products[v.Id]; found {
+			result = append(result, p)
+		}
 	}
 
-	return result, total, nil
+	return result, nil
 }
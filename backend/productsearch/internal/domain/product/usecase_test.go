@@ -2,8 +2,10 @@ package product
 
 import (
 	"context"
+	"errors"
 	"github.com/ringbrew/newaim/productsearch/internal/conf"
 	"github.com/ringbrew/newaim/productsearch/internal/domain"
+	"github.com/ringbrew/newaim/productsearch/internal/domain/embedding"
 	"log"
 	"testing"
 )
@@ -17,17 +19,53 @@ func TestQuery(t *testing.T) {
 
 	uc := NewUseCase(ctx)
 
-	result, total, err := uc.Query(context.Background(), "V539-NIK-DD6337-661-L", 0, 10)
+	resp, err := uc.Query(context.Background(), QueryRequest{
+		Keyword: "V539-NIK-DD6337-661-L",
+		From:    0,
+		Size:    10,
+	})
 	if err != nil {
 		t.Error(err.Error())
 		return
 	}
 
-	for _, v := range result {
+	for _, v := range resp.Data {
 		log.Println(v.SKU)
 		log.Println(v.Score)
 		//log.Println(v.Description)
 	}
-	log.Println(len(result))
-	log.Println(total)
+	log.Println(len(resp.Data))
+	log.Println(resp.Total)
+}
+
+// answers with an index past the batch.
+type shiftedEmbedding struct{}
+
+func (shiftedEmbedding) EmbedDocument(ctx context.Context, req embedding.DocumentRequest) (embedding.DocumentResponse, error) {
+	resp := embedding.DocumentResponse{}
+	for i, v := range req.Documents {
+		resp.Data = append(resp.Data, embedding.Data{Content: v, Vector: embedding.Vector{1}, Index: i + 1})
+	}
+	return resp, nil
+}
+
+func (shiftedEmbedding) EmbedSingle(ctx context.Context, req embedding.SingleRequest) (embedding.SingleResponse, error) {
+	return embedding.SingleResponse{}, nil
+}
+
+func TestEmbedManyIndex(t *testing.T) {
+	uc := &UseCase{
+		ctx: &domain.UseCaseContext{Config: conf.Config{Embedding: conf.Embedding{Batch: conf.EmbeddingBatch{MaxRetries: -1}}}},
+		em:  shiftedEmbedding{},
+	}
+	ms, err := newMemoryStore(MetricL2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = uc.embedMany(context.Background(), ms, []*Product{{Id: "1", SKU: "A", Description: "shoe"}})
+	var bce *BatchCreateError
+	if !errors.As(err, &bce) || len(bce.Failed) != 1 || bce.Failed[0].SKU != "A" {
+		t.Errorf("an out of range index should fail the batch: %v", err)
+	}
 }
@@ -0,0 +1,71 @@
+package product
+
+import (
+	"context"
+	"fmt"
+	"github.com/ringbrew/newaim/productsearch/internal/domain"
+	"github.com/ringbrew/newaim/productsearch/internal/domain/embedding"
+)
+
+const (
+	VectorStoreMilvus  = "milvus"
+	VectorStoreMemory  = "memory"
+	VectorStoreElastic = "elasticsearch"
+)
+
+type VectorStore interface {
+	// BatchCreate stores the vectors of a batch, stores may buffer them.
+	BatchCreate(ctx context.Context, ds []*Product) error
+	// Upsert stores the vector of a product from the crud api, searchable once it returns.
+	Upsert(ctx context.Context, p *Product) error
+	Delete(ctx context.Context, p Product) error
+	Query(ctx context.Context, request QueryVectorRequest) (QueryVectorResponse, error)
+}
+
+/*
+@desc: implemented by stores writing into the product index, so a rebuild can direct them to the new version.
+*/
+type indexBoundStore interface {
+	withIndex(index string) VectorStore
+}
+
+type QueryVectorRequest struct {
+	Input embedding.Vector
+	Top   int
+	// Filter is applied by stores that keep the product, others are filtered when hydrated.
+	Filter Filter
+}
+
+type QueryVectorRes struct {
+	Id    string
+	Score float32
+	// Product is set by stores that return the stored product with the vector.
+	Product *Product
+}
+
+type QueryVectorResponse struct {
+	Data []QueryVectorRes
+}
+
+/*
+@desc: new vector store by config, return nil store when vector store is not configured.
+*/
+func newVectorStore(ctx *domain.UseCaseContext, r *repo) (VectorStore, error) {
+	storeType := ctx.Config.VectorStore.Type
+	if storeType == "" && ctx.Config.Miluvs.Endpoint != "" {
+		storeType = VectorStoreMilvus
+	}
+
+	switch storeType {
+	case "":
+		return nil, nil
+	case VectorStoreMilvus:
+		return newMilvusStore(ctx)
+	case VectorStoreMemory:
+		return newMemoryStore(ctx.Config.VectorStore.Metric)
+	case VectorStoreElastic:
+		return newElasticStore(r, ctx.Config.VectorStore)
+	default:
+		return nil, fmt.Errorf("vector store-[%s] not support", storeType)
+	}
+}
@@ -1 +1,3 @@
-export const baseUrl =  'http://localhost:3545'
\ No newline at end of file
+export const baseUrl =  'http://localhost:3545'
+// issued by POST /apikey, baked in at build time, see API key in README.md
+export const apiKey = process.env.NEXT_PUBLIC_API_KEY || ''
\ No newline at end of file